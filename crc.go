@@ -0,0 +1,158 @@
+package ebmlstream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// A CRC32 container whose CRC-32 is calculated as its data is consumed from the
+// stream, see VerifyCRC32
+type crcCheck struct {
+	c   *Elem
+	end int64
+	h   hash.Hash32
+
+	// The CRC32Value inside the container, which is left out of the CRC-32,
+	// the position it ends at, and its data
+	val    *Elem
+	valEnd int64
+	value  []byte
+}
+
+// Causes the contents of each CRC32 container (of known size) read from the
+// same stream to be checked against the CRC32Value inside of it, which must be
+// the little-endian IEEE CRC-32 of all the container's other children (as
+// written by an Encoder, see CRC32). The CRC-32 is calculated as the
+// container's data is consumed from the stream, so nothing extra is held in
+// memory, but while inside of the container Skip() reads data rather than
+// seeking past it. A container which is skipped over itself isn't checked.
+//
+// Once the container has ended the next call to Next() returns a *ParseError
+// for it, wrapping CRCMismatch if the CRC-32 doesn't match or SchemaViolation if
+// the container doesn't hold a 4 byte CRC32Value. The Elem read after the
+// container is then returned by the call to Next() after that.
+func (e *Elem) VerifyCRC32() {
+	e.s.verifyCRC = true
+}
+
+// Starts calculating the CRC-32 of the container, which is being entered, if
+// it's a CRC32 container which needs verifying
+func (s *stream) startCRC(c *Elem) {
+	if !s.verifyCRC || c.Id != crc32ID {
+		return
+	}
+	if end, ok := c.End(); ok {
+		s.crcs = append(s.crcs, &crcCheck{c: c, end: end, h: crc32.NewIEEE()})
+	}
+}
+
+// Adds the header of the Elem, which was just read, to the CRC-32s of the
+// containers it's inside of. If it's the CRC32Value of one of them it's left
+// out of that one's instead
+func (s *stream) hashHeader(el *Elem) {
+	var hb [maxHeaderSize]byte
+	header, _ := el.Id.Append(hb[:0])
+	header, _ = el.Size.Append(header)
+
+	for _, crc := range s.crcs {
+		if el.Offset >= crc.end {
+			continue
+		} else if el.Id == crc32ValueID && el.Parent == crc.c && crc.val == nil {
+			crc.val, crc.valEnd = el, crc.end
+			if end, ok := el.End(); ok && end < crc.end {
+				crc.valEnd = end
+			}
+			continue
+		}
+		crc.h.Write(header)
+	}
+}
+
+// Adds the data in b, which is about to be consumed from the stream, to the
+// CRC-32s of the containers it's inside of
+func (s *stream) hashData(b []byte) {
+	for _, crc := range s.crcs {
+		crc.write(s.pos, b)
+	}
+}
+
+// Adds the data in b, which starts at pos in the stream, to the CRC-32. Any of
+// it which is the CRC32Value's data is kept as the value instead, as long as
+// it's the right size
+func (crc *crcCheck) write(pos int64, b []byte) {
+	if pos >= crc.end {
+		return
+	} else if rem := crc.end - pos; rem < int64(len(b)) {
+		b = b[:rem]
+	}
+
+	end := pos + int64(len(b))
+	if crc.val == nil || end <= crc.val.DataOffset || pos >= crc.valEnd {
+		crc.h.Write(b)
+		return
+	}
+
+	valStart := max(crc.val.DataOffset-pos, 0)
+	valEnd := min(crc.valEnd-pos, int64(len(b)))
+	crc.h.Write(b[:valStart])
+	if crc.valEnd-crc.val.DataOffset == 4 {
+		crc.value = append(crc.value, b[valStart:valEnd]...)
+	}
+	crc.h.Write(b[valEnd:])
+}
+
+// Checks the CRC-32s of the containers being verified which are no longer
+// open, now that the stream has reached pos. Any which were ended before all of
+// their data was read (see EndFunc) are dropped. If any fail the error for the
+// innermost is returned
+func (s *stream) endCRCs(pos int64) error {
+	var err error
+	crcs := s.crcs[:0]
+	for _, crc := range s.crcs {
+		if s.isOpen(crc.c) {
+			crcs = append(crcs, crc)
+		} else if pos >= crc.end {
+			if verr := crc.verify(); verr != nil {
+				err = verr
+			}
+		}
+	}
+	s.crcs = crcs
+	return err
+}
+
+// Returns true if the container hasn't been ended yet
+func (s *stream) isOpen(c *Elem) bool {
+	for _, o := range s.open {
+		if o == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares the calculated CRC-32 to the CRC32Value, once all of the container's
+// data has been read
+func (crc *crcCheck) verify() error {
+	c := crc.c
+	if crc.val == nil {
+		return parseErr(c.Offset, c.Id, fmt.Errorf(
+			"%w: CRC32 container has no CRC32Value", SchemaViolation,
+		))
+	} else if len(crc.value) != 4 {
+		return parseErr(c.Offset, c.Id, fmt.Errorf(
+			"%w: CRC32Value is %d bytes, must be 4",
+			SchemaViolation, crc.valEnd-crc.val.DataOffset,
+		))
+	}
+
+	expected := binary.LittleEndian.Uint32(crc.value)
+	if sum := crc.h.Sum32(); sum != expected {
+		return parseErr(c.Offset, c.Id, fmt.Errorf(
+			"%w: expected %08x, computed %08x", CRCMismatch, expected, sum,
+		))
+	}
+	return nil
+}
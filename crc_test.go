@@ -0,0 +1,117 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io"
+	. "testing"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// Returns a CRC32 container holding the given children, followed by a
+// CRC32Value with the given data, or no CRC32Value if it's nil
+func crcContainer(children, value []byte) []byte {
+	inner := append([]byte{}, children...)
+	if value != nil {
+		inner = append(inner, 0x42, 0xfe, 0x80|byte(len(value)))
+		inner = append(inner, value...)
+	}
+	return append([]byte{0xc3, 0x80 | byte(len(inner))}, inner...)
+}
+
+func crcValue(b []byte) []byte {
+	return binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(b))
+}
+
+// Reads all elements off the stream, treating CRC32 containers as containers
+// and reading the data of everything else. The ids read are returned, along
+// with the first error
+func readCRC(in []byte, skip bool) ([]varint.VarInt, error) {
+	e := RootElem(bytes.NewReader(in))
+	e.VerifyCRC32()
+
+	var ids []varint.VarInt
+	var first error
+	for {
+		el, err := e.Next()
+		if err == io.EOF {
+			return ids, first
+		} else if err != nil && first != nil {
+			return ids, first
+		} else if err != nil {
+			// The Elem after a CRC32 container is returned after its error
+			first = err
+			continue
+		}
+		ids = append(ids, el.Id)
+		if el.Id == crc32ID {
+			if skip {
+				if err := el.Skip(); err != nil {
+					return ids, err
+				}
+			}
+		} else if el.Id == 0x83 {
+			// skipped rather than read, the CRC-32 is still calculated
+			if err := el.Skip(); err != nil {
+				return ids, err
+			}
+		} else if _, err := el.Bytes(); err != nil {
+			return ids, err
+		}
+		e = el
+	}
+}
+
+func TestVerifyCRC32(t *T) {
+	children := []byte{0x83, 0x81, 0x01, 0x84, 0x82, 'h', 'i'}
+	after := []byte{0x85, 0x80}
+
+	good := crcContainer(children, crcValue(children))
+	ids, err := readCRC(append(good, after...), false)
+	require.Nil(t, err)
+	assert.Equal(t, []varint.VarInt{0xc3, 0x83, 0x84, 0x42fe, 0x85}, ids)
+
+	// Ending with the stream
+	_, err = readCRC(good, false)
+	require.Nil(t, err)
+
+	// The CRC32Value doesn't have to come last
+	good = crcContainer(nil, crcValue(children))
+	good = append(good[:2:2], append(good[2:], children...)...)
+	good[1] += byte(len(children))
+	_, err = readCRC(good, false)
+	require.Nil(t, err)
+
+	cases := []struct {
+		in    []byte
+		cause error
+	}{
+		{crcContainer(children, crcValue([]byte{1})), CRCMismatch},
+		{crcContainer(children, nil), SchemaViolation},
+		{crcContainer(children, []byte{0xaa, 0xbb}), SchemaViolation},
+		{crcContainer(children, append(crcValue(children), 0)), SchemaViolation},
+	}
+	for i, c := range cases {
+		// The error is returned once the container ends, and the Elem after it
+		// is still returned
+		ids, err := readCRC(append(c.in, after...), false)
+		var perr *ParseError
+		require.True(t, errors.As(err, &perr), "case: %d err: %v", i, err)
+		assert.True(t, errors.Is(err, c.cause), "case: %d err: %v", i, err)
+		assert.Equal(t, int64(0), perr.Offset, "case: %d", i)
+		assert.Exactly(t, varint.VarInt(0xc3), perr.ElementID, "case: %d", i)
+		assert.Equal(t, varint.VarInt(0x85), ids[len(ids)-1], "case: %d", i)
+
+		_, err = readCRC(c.in, false)
+		assert.True(t, errors.Is(err, c.cause), "case: %d err: %v", i, err)
+
+		// A CRC32 container which is skipped isn't checked
+		_, err = readCRC(append(c.in, after...), true)
+		assert.Nil(t, err, "case: %d", i)
+	}
+}
@@ -0,0 +1,108 @@
+package ebmlstream
+
+import (
+	"fmt"
+	"io"
+)
+
+// The largest possible element header is an 8 byte id followed by an 8 byte
+// size, so this is as much as needs to be buffered when reading one in
+const maxHeaderSize = 16
+
+// Document provides random access to an ebml stream which is stored somewhere
+// which implements io.ReaderAt (e.g. an os.File or an mmap'd byte slice),
+// as opposed to the forward-only access provided by RootElem. Nothing is read
+// until an Elem is asked for, and reading one Elem has no effect on any others.
+type Document struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// Returns a Document for the given io.ReaderAt, which holds size bytes
+func NewDocument(r io.ReaderAt, size int64) *Document {
+	return &Document{
+		r:    r,
+		size: size,
+	}
+}
+
+// Returns the total size of the Document in bytes
+func (d *Document) Size() int64 {
+	return d.size
+}
+
+// Returns the Elem whose id begins at the given offset in the Document. The
+// data methods can be called on the returned Elem as with any other, and
+// calling Next() on it will read forward through the Document from that point.
+func (d *Document) ElemAt(offset int64) (*Elem, error) {
+	if offset < 0 || offset >= d.size {
+		return nil, fmt.Errorf("offset %d outside of document", offset)
+	}
+	r := io.NewSectionReader(d.r, offset, d.size-offset)
+	root := &Elem{s: newStreamAt(r, offset, maxHeaderSize)}
+	e, err := root.Next()
+	if err == io.EOF {
+		return nil, parseErr(offset, 0, err)
+	}
+	return e, err
+}
+
+// Returns an io.SectionReader over the given Elem's data. The Elem must have
+// come from this Document, or from a stream over the same data. This can be
+// called any number of times, regardless of whether the Elem's data has been
+// read already.
+func (d *Document) DataReader(e *Elem) (*io.SectionReader, error) {
+	if e.UnknownSize {
+		return nil, SizeUnknown
+	}
+	size, err := e.Size.Uint64()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(d.r, e.DataOffset, int64(size)), nil
+}
+
+// ChildIter is used to lazily iterate over the direct children of a container
+// in a Document. It is created using the Children method on Document
+type ChildIter struct {
+	d         *Document
+	next, end int64
+}
+
+// Returns a ChildIter for the given container Elem. If parent is nil the
+// top-level elements of the Document are iterated over instead. A container of
+// unknown size is assumed to extend to the end of the Document.
+func (d *Document) Children(parent *Elem) (*ChildIter, error) {
+	if parent == nil {
+		return &ChildIter{d: d, end: d.size}, nil
+	}
+
+	end := d.size
+	if pend, ok := parent.End(); ok {
+		end = pend
+	}
+	return &ChildIter{d: d, next: parent.DataOffset, end: end}, nil
+}
+
+// Returns the next child Elem, or io.EOF if there are no more. Only the
+// child's header is read, its data (and its own children) are not. If a child
+// has unknown size it's impossible to know where its next sibling starts, so
+// the Next() call after it returns SizeUnknown.
+func (c *ChildIter) Next() (*Elem, error) {
+	if c.next < 0 {
+		return nil, SizeUnknown
+	} else if c.next >= c.end {
+		return nil, io.EOF
+	}
+
+	e, err := c.d.ElemAt(c.next)
+	if err != nil {
+		return nil, err
+	}
+
+	c.next = -1
+	if end, ok := e.End(); ok {
+		c.next = end
+	}
+	return e, nil
+}
@@ -0,0 +1,79 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	. "testing"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+func TestDocument(t *T) {
+	in := []byte{
+		0x81, 0x87,
+		0x82, 0x81, 0x01,
+		0x83, 0x82, 'h', 'i',
+		0x84, 0x80,
+	}
+	d := NewDocument(bytes.NewReader(in), int64(len(in)))
+
+	top, err := d.Children(nil)
+	require.Nil(t, err)
+	parent, err := top.Next()
+	require.Nil(t, err)
+	assert.Exactly(t, varint.VarInt(0x81), parent.Id)
+
+	children, err := d.Children(parent)
+	require.Nil(t, err)
+	var ids []varint.VarInt
+	var elems []*Elem
+	for {
+		e, err := children.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		ids = append(ids, e.Id)
+		elems = append(elems, e)
+	}
+	assert.Equal(t, []varint.VarInt{0x82, 0x83}, ids)
+
+	// Data can be read from the elems in any order, and multiple times via
+	// DataReader
+	s, err := elems[1].Str()
+	require.Nil(t, err)
+	assert.Equal(t, "hi", s)
+	i, err := elems[0].Uint()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), i)
+
+	sr, err := d.DataReader(elems[1])
+	require.Nil(t, err)
+	b, err := io.ReadAll(sr)
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hi"), b)
+
+	e, err := d.ElemAt(9)
+	require.Nil(t, err)
+	assert.Exactly(t, varint.VarInt(0x84), e.Id)
+
+	e, err = top.Next()
+	require.Nil(t, err)
+	assert.Exactly(t, varint.VarInt(0x84), e.Id)
+	_, err = top.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDocumentTruncated(t *T) {
+	in := []byte{0x81, 0x80}
+	d := NewDocument(bytes.NewReader(in), int64(len(in))+2)
+
+	_, err := d.ElemAt(2)
+	var perr *ParseError
+	require.True(t, errors.As(err, &perr), "err: %v", err)
+	assert.Equal(t, int64(2), perr.Offset)
+	assert.True(t, errors.Is(err, Truncated))
+}
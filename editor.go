@@ -0,0 +1,174 @@
+package ebmlstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// The id of the Void element, which can appear anywhere and whose data has no
+// meaning. It's used as padding, to leave space for elements to grow into
+const VoidID = varint.VarInt(0xec)
+
+// The smallest possible Void element, an id and a size of zero
+const minVoidSize = 2
+
+var NoRoom = errors.New("not enough room to write element in place")
+
+// Returns true if the Elem is a Void element
+func (e *Elem) IsVoid() bool {
+	return e.Id == VoidID
+}
+
+// ReaderWriterAt is implemented by anything which can be both read from and
+// written to at arbitrary offsets, e.g. an os.File
+type ReaderWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Editor is a Document which can also have its elements overwritten in place.
+// Elements can be replaced with new ones of a different size, as long as the
+// difference can be absorbed by a Void element directly after them, so that
+// nothing else in the Document has to move. This makes it possible to, for
+// example, update the tags in a large file without having to rewrite all of it.
+type Editor struct {
+	*Document
+	w io.WriterAt
+}
+
+// Returns an Editor for the given ReaderWriterAt, which holds size bytes
+func NewEditor(rw ReaderWriterAt, size int64) *Editor {
+	return &Editor{
+		Document: NewDocument(rw, size),
+		w:        rw,
+	}
+}
+
+// Overwrites the given Elem, which must have been retrieved from this Editor,
+// with b. b must be a single complete encoded element of known size, header
+// included (e.g. as written by an Encoder), otherwise an error is returned.
+// parent is the container the Elem is in, or nil if
+// it's a top-level element, like with Children. The Elem is invalid after
+// this, ElemAt can be used to read the new element.
+//
+// If b is smaller than the Elem the space left over is filled with a Void
+// element, or added on to the Void directly after the Elem if there is one. If
+// b is larger it can take up space from a Void directly after the Elem, as
+// long as that Void is also inside of parent. If there isn't enough space for
+// b NoRoom is returned and nothing is written.
+func (ed *Editor) Replace(parent, e *Elem, b []byte) error {
+	if _, _, err := decodeHeader(b); err != nil {
+		return err
+	}
+
+	end, ok := e.End()
+	if !ok {
+		return SizeUnknown
+	}
+
+	// A parent of unknown size is taken to extend to the end of the Document,
+	// as with Children
+	parentStart, parentEnd := int64(0), ed.size
+	if parent != nil {
+		parentStart = parent.DataOffset
+		if pend, ok := parent.End(); ok {
+			parentEnd = pend
+		}
+	}
+	if e.Offset < parentStart || end > parentEnd {
+		return fmt.Errorf("element at offset %d isn't inside of its parent", e.Offset)
+	}
+
+	if end < parentEnd {
+		next, err := ed.ElemAt(end)
+		if err != nil {
+			return err
+		} else if voidEnd, ok := next.End(); ok && next.IsVoid() && voidEnd <= parentEnd {
+			end = voidEnd
+		}
+	}
+
+	gap := end - e.Offset - int64(len(b))
+	if gap < 0 {
+		return NoRoom
+	} else if gap > 0 && gap < minVoidSize {
+		// There's no room for a Void, so instead b's size is given an extra
+		// byte
+		wide, err := widenSize(b)
+		if err != nil {
+			return NoRoom
+		}
+		b = wide
+		gap = 0
+	}
+
+	if gap > 0 {
+		void, err := voidHeader(gap)
+		if err != nil {
+			return err
+		}
+		b = append(b[:len(b):len(b)], void...)
+	}
+	_, err := ed.w.WriteAt(b, e.Offset)
+	return err
+}
+
+// Returns the header of a Void element which, including its data, takes up
+// exactly n bytes
+func voidHeader(n int64) ([]byte, error) {
+	for width := 1; width <= 8; width++ {
+		size := n - 1 - int64(width)
+		if size < 0 {
+			break
+		}
+		if b, err := encodeSizeWidth(uint64(size), width); err == nil {
+			return append([]byte{byte(VoidID)}, b...), nil
+		}
+	}
+	return nil, NoRoom
+}
+
+// Decodes the header of the encoded element b, returning the widths of its id
+// and size. An error is returned unless b is exactly one element of known size
+func decodeHeader(b []byte) (idWidth, sizeWidth int, err error) {
+	if _, idWidth, err = varint.Decode(b); err != nil {
+		return 0, 0, err
+	}
+	size, sizeWidth, err := varint.Decode(b[idWidth:])
+	if err != nil {
+		return 0, 0, err
+	} else if size.IsUnknown() {
+		return 0, 0, SizeUnknown
+	}
+
+	n, err := size.Uint64()
+	if err != nil {
+		return 0, 0, err
+	} else if data := len(b) - idWidth - sizeWidth; n != uint64(data) {
+		return 0, 0, fmt.Errorf(
+			"element's size is %d but it has %d bytes of data", n, data,
+		)
+	}
+	return idWidth, sizeWidth, nil
+}
+
+// Returns the encoded element with its size rewritten to take up one more byte
+func widenSize(b []byte) ([]byte, error) {
+	idWidth, sizeWidth, err := decodeHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	n := uint64(len(b) - idWidth - sizeWidth)
+	sb, err := encodeSizeWidth(n, sizeWidth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	wide := make([]byte, 0, len(b)+1)
+	wide = append(wide, b[:idWidth]...)
+	wide = append(wide, sb...)
+	return append(wide, b[idWidth+sizeWidth:]...), nil
+}
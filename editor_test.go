@@ -0,0 +1,170 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "testing"
+)
+
+// An in-memory ReaderWriterAt of fixed size
+type memFile []byte
+
+func (m memFile) ReadAt(b []byte, off int64) (int, error) {
+	return bytes.NewReader(m).ReadAt(b, off)
+}
+
+func (m memFile) WriteAt(b []byte, off int64) (int, error) {
+	return copy(m[off:], b), nil
+}
+
+func TestEditorReplace(t *T) {
+	in := []byte{
+		0x81, 0x8f,
+		0x82, 0x84, 'a', 'b', 'c', 'd',
+		0xec, 0x84, 0, 0, 0, 0,
+		0x83, 0x81, 0x01,
+	}
+
+	cases := []struct {
+		offset int64
+		b      []byte
+		expect []byte
+		err    error
+	}{
+		// grow into the Void
+		{
+			offset: 2,
+			b:      []byte{0x82, 0x87, 'a', 'b', 'c', 'd', 'e', 'f', 'g'},
+			expect: []byte{
+				0x81, 0x8f,
+				0x82, 0x87, 'a', 'b', 'c', 'd', 'e', 'f', 'g',
+				0xec, 0x81, 0,
+				0x83, 0x81, 0x01,
+			},
+		},
+		// shrink, growing the Void
+		{
+			offset: 2,
+			b:      []byte{0x82, 0x82, 'a', 'b'},
+			expect: []byte{
+				0x81, 0x8f,
+				0x82, 0x82, 'a', 'b',
+				0xec, 0x86, 0xec, 0x84, 0, 0, 0, 0,
+				0x83, 0x81, 0x01,
+			},
+		},
+		// take up all but one byte, so the size gets widened
+		{
+			offset: 2,
+			b:      []byte{0x82, 0x89, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i'},
+			expect: []byte{
+				0x81, 0x8f,
+				0x82, 0x40, 0x09, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i',
+				0x83, 0x81, 0x01,
+			},
+		},
+		// too big, even with the Void
+		{
+			offset: 2,
+			b:      []byte{0x82, 0x8b, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k'},
+			err:    NoRoom,
+		},
+		// no Void after the last element
+		{
+			offset: 14,
+			b:      []byte{0x83, 0x82, 0x01, 0x02},
+			err:    NoRoom,
+		},
+		{
+			offset: 14,
+			b:      []byte{0x83, 0x81, 0x02},
+			expect: []byte{
+				0x81, 0x8f,
+				0x82, 0x84, 'a', 'b', 'c', 'd',
+				0xec, 0x84, 0, 0, 0, 0,
+				0x83, 0x81, 0x02,
+			},
+		},
+	}
+
+	for i, c := range cases {
+		m := memFile(append([]byte{}, in...))
+		ed := NewEditor(m, int64(len(m)))
+		parent, err := ed.ElemAt(0)
+		require.Nil(t, err, "case: %d", i)
+		e, err := ed.ElemAt(c.offset)
+		require.Nil(t, err, "case: %d", i)
+
+		err = ed.Replace(parent, e, c.b)
+		if c.err != nil {
+			assert.Equal(t, c.err, err, "case: %d", i)
+			assert.Equal(t, in, []byte(m), "case: %d", i)
+			continue
+		}
+		require.Nil(t, err, "case: %d", i)
+		assert.Equal(t, c.expect, []byte(m), "case: %d", i)
+
+		e, err = ed.ElemAt(c.offset)
+		require.Nil(t, err, "case: %d", i)
+		b, err := e.Bytes()
+		require.Nil(t, err, "case: %d", i)
+		assert.Equal(t, c.b[len(c.b)-len(b):], b, "case: %d", i)
+	}
+}
+
+func TestEditorReplaceParent(t *T) {
+	// The Void is after the container, not inside of it, so it can't be used
+	in := []byte{
+		0x81, 0x83,
+		0x82, 0x81, 'a',
+		0xec, 0x84, 0, 0, 0, 0,
+	}
+	m := memFile(append([]byte{}, in...))
+	ed := NewEditor(m, int64(len(m)))
+	parent, err := ed.ElemAt(0)
+	require.Nil(t, err)
+	e, err := ed.ElemAt(2)
+	require.Nil(t, err)
+
+	assert.Equal(t, NoRoom, ed.Replace(parent, e, []byte{0x82, 0x84, 'a', 'b', 'c', 'd'}))
+	assert.Equal(t, in, []byte(m))
+
+	// The given parent must actually contain the Elem
+	assert.NotNil(t, ed.Replace(e, parent, []byte{0x81, 0x80}))
+	assert.Equal(t, in, []byte(m))
+
+	// The Void is top-level, so the container itself can grow into it
+	require.Nil(t, ed.Replace(nil, parent, []byte{0x81, 0x85, 0x82, 0x83, 'a', 'b', 'c'}))
+	assert.Equal(t, []byte{
+		0x81, 0x85,
+		0x82, 0x83, 'a', 'b', 'c',
+		0xec, 0x82, 0, 0,
+	}, []byte(m))
+}
+
+func TestEditorReplaceInvalid(t *T) {
+	in := []byte{
+		0x81, 0x88,
+		0x82, 0x81, 'a',
+		0xec, 0x83, 0, 0, 0,
+	}
+	m := memFile(append([]byte{}, in...))
+	ed := NewEditor(m, int64(len(m)))
+	parent, err := ed.ElemAt(0)
+	require.Nil(t, err)
+	e, err := ed.ElemAt(2)
+	require.Nil(t, err)
+
+	// b must be a single element of known size, whose size matches its data
+	for _, b := range [][]byte{
+		{0x82, 0x90, 'a'},
+		{0x82, 0x81, 'a', 'b'},
+		{0x82, 0xff, 'a'},
+		{0x82},
+		{},
+	} {
+		assert.NotNil(t, ed.Replace(parent, e, b), "b: %x", b)
+		assert.Equal(t, in, []byte(m), "b: %x", b)
+	}
+}
@@ -15,7 +15,9 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mediocregopher/ebmlstream"
 	"github.com/mediocregopher/ebmlstream/varint"
 )
 
@@ -28,6 +30,7 @@ const (
 	Date
 	Binary
 	Container
+	UTF8
 )
 
 type card int
@@ -55,6 +58,14 @@ type tplElement struct {
 	card
 	ranges       *rangeParam
 	mustMatchDef bool
+
+	// global elements (e.g. Void) are given a level range with no upper bound
+	// and can appear as the child of any container
+	global bool
+
+	// The id of the container the element is defined in, or 0 if it's a
+	// top-level element
+	parent elementID
 }
 
 // Edtd is generated from an edtd specification. It can be used to generate one
@@ -146,7 +157,7 @@ func NewEdtd(r io.Reader) (*Edtd, error) {
 	t := typesMap{}
 
 	implicitBuf := bytes.NewBufferString(implicitElements)
-	if err := parseElements(newLexer(implicitBuf), m, t, 0, false); err != nil {
+	if err := parseElements(newLexer(implicitBuf), m, t, 0, 0, false); err != nil {
 		return nil, err
 	}
 
@@ -169,7 +180,7 @@ func NewEdtd(r io.Reader) (*Edtd, error) {
 
 		switch defWhat.val {
 		case "elements":
-			if err = parseElements(lex, m, t, 0, false); err != nil {
+			if err = parseElements(lex, m, t, 0, 0, false); err != nil {
 				return nil, err
 			}
 
@@ -190,7 +201,7 @@ func NewEdtd(r io.Reader) (*Edtd, error) {
 // indexes by the name instead of the id
 func parseTypes(lex *lexer, t typesMap) error {
 	fakem := elementMap{}
-	err := parseElements(lex, fakem, t, 0, true)
+	err := parseElements(lex, fakem, t, 0, 0, true)
 	if err != nil {
 		return err
 	}
@@ -202,13 +213,15 @@ func parseTypes(lex *lexer, t typesMap) error {
 	return nil
 }
 
-// dontExpectId is used by parseTypes, which parses exactly like parseElements
-// except that there are no ids
+// parent is the id of the container the elements are in, or 0 for top-level
+// elements. dontExpectId is used by parseTypes, which parses exactly like
+// parseElements except that there are no ids
 func parseElements(
-	lex *lexer, m elementMap, t typesMap, level uint64, dontExpectId bool,
+	lex *lexer, m elementMap, t typesMap, level uint64, parent elementID,
+	dontExpectId bool,
 ) error {
 	for {
-		err, done := parseElement(lex, m, t, level, dontExpectId)
+		err, done := parseElement(lex, m, t, level, parent, dontExpectId)
 		if err != nil {
 			return err
 		} else if done {
@@ -223,7 +236,8 @@ func parseElements(
 // thise case it returns the third argument as true and doesn't parse anything
 // out
 func parseElement(
-	lex *lexer, m elementMap, t typesMap, level uint64, dontExpectId bool,
+	lex *lexer, m elementMap, t typesMap, level uint64, parent elementID,
+	dontExpectId bool,
 ) (
 	error, bool,
 ) {
@@ -239,7 +253,7 @@ func parseElement(
 		if _, err = expect(lex, &semiColonTok); err != nil {
 			return err, false
 		}
-		return parseElement(lex, m, t, level, dontExpectId)
+		return parseElement(lex, m, t, level, parent, dontExpectId)
 	} else if nameTok.typ != alphaNum {
 		return fmt.Errorf("unexpected '%s' found", nameTok), false
 	}
@@ -271,15 +285,17 @@ func parseElement(
 	var elem tplElement
 	if typ, ok := strToType(typTok.val); ok {
 		elem = tplElement{
-			id:    id,
-			typ:   typ,
-			name:  nameTok.val,
-			level: level,
+			id:     id,
+			typ:    typ,
+			name:   nameTok.val,
+			level:  level,
+			parent: parent,
 		}
 	} else if typTpl, ok := t[strings.ToLower(typTok.val)]; ok {
 		elem = *typTpl
 		elem.id = id
 		elem.name = nameTok.val
+		elem.parent = parent
 	} else {
 		return fmt.Errorf("unknown type: '%s'", typTok.val), false
 	}
@@ -320,7 +336,7 @@ func parseElement(
 		}
 	}
 
-	if err = parseElements(lex, m, t, level+1, dontExpectId); err != nil {
+	if err = parseElements(lex, m, t, level+1, elem.id, dontExpectId); err != nil {
 		return err, false
 	}
 
@@ -346,6 +362,8 @@ func strToType(s string) (Type, bool) {
 		return Float, true
 	case "string":
 		return String, true
+	case "utf-8", "utf8":
+		return UTF8, true
 	case "date":
 		return Date, true
 	case "binary":
@@ -406,6 +424,11 @@ func parseParam(lex *lexer, elem *tplElement) (error, bool) {
 		if _, err := expect(lex, &semiColonTok); err != nil {
 			return err, false
 		}
+	case "level":
+		parseLevelParam(elem, pvalTok)
+		if _, err := expect(lex, &semiColonTok); err != nil {
+			return err, false
+		}
 	case "size":
 		if err := parseSizeParam(elem, pvalTok); err != nil {
 			return err, false
@@ -484,7 +507,7 @@ func parseDefParam(elem *tplElement, pvalTok *token) error {
 			return nil
 		}
 		return setDefData(elem, &f)
-	case String, Binary:
+	case String, UTF8, Binary:
 		if pvalTok.val[:2] == "0x" {
 			s, err := hex.DecodeString(pvalTok.val[2:])
 			if err != nil {
@@ -503,12 +526,55 @@ func parseDefParam(elem *tplElement, pvalTok *token) error {
 		elem.def = []byte(s)
 		return nil
 	case Date:
-		return fmt.Errorf("Default date not supported yet")
+		i, err := parseDate(pvalTok)
+		if err != nil {
+			return err
+		}
+		return setDefData(elem, &i)
 	default:
 		return fmt.Errorf("Found default on unsupported type")
 	}
 }
 
+// The layouts which date defaults may be given in. Unquoted dates can't contain
+// a colon, so can only be given as a date without a time
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"20060102",
+}
+
+// Parses a date default, which is either an integer (the raw number of
+// nanoseconds from ebmlstream.DateEpoch) or an ISO-8601 date/time, and returns
+// it in its raw form. Date/times without a timezone are taken to be in UTC. An
+// unquoted number is always taken to be an integer, so compact dates (e.g.
+// "20010101") must be quoted
+func parseDate(pvalTok *token) (int64, error) {
+	val := pvalTok.val
+	if pvalTok.typ == quotedString {
+		var err error
+		if val, err = strconv.Unquote(val); err != nil {
+			return 0, err
+		}
+	} else if i, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return i, nil
+	}
+
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, val, time.UTC)
+		if err != nil {
+			continue
+		}
+		b, err := ebmlstream.EncodeDate(t)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	}
+	return 0, fmt.Errorf("invalid date '%s'", val)
+}
+
 func setDefData(elem *tplElement, d interface{}) error {
 	if b, err := defDataBytes(d); err != nil {
 		return err
@@ -518,6 +584,14 @@ func setDefData(elem *tplElement, d interface{}) error {
 	}
 }
 
+// Only open-ended level ranges (e.g. "1..") are meaningful to us, since they
+// mark the element as being allowed anywhere in the tree
+func parseLevelParam(elem *tplElement, pvalTok *token) {
+	if strings.HasSuffix(pvalTok.val, "..") {
+		elem.global = true
+	}
+}
+
 func parseSizeParam(elem *tplElement, pvalTok *token) error {
 	i, err := strconv.ParseUint(pvalTok.val, 10, 64)
 	if err != nil {
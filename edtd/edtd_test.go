@@ -172,3 +172,57 @@ func TestParseFloatRange(t *T) {
 
 	assert.Equal(t, foo, e.elements[0x13ab])
 }
+
+func TestParseUTF8(t *T) {
+	test := `
+        define elements {
+		    Foo := 53ab utf-8 [ def:"héllo"; ]
+		    Bar := 53ac utf8;
+		}
+	`
+
+	e, err := NewEdtd(bytes.NewBufferString(test))
+	require.Nil(t, err)
+
+	assert.Equal(t, UTF8, e.elements[0x53ab].typ)
+	assert.Equal(t, []byte("héllo"), e.elements[0x53ab].def)
+	assert.Equal(t, UTF8, e.elements[0x53ac].typ)
+}
+
+func TestParseDateDef(t *T) {
+	test := `
+        define elements {
+		    A := 4461 date [ def:"2001-01-01T00:00:01Z"; ]
+		    B := 4462 date [ def:"2001-01-01T00:00:00.5"; ]
+		    C := 4463 date [ def:2001-01-02; ]
+		    D := 4464 date [ def:-5; ]
+		    E := 4465 date [ def:20010102; ]
+		    F := 4466 date [ def:"20010102"; ]
+		    G := 4467 date [ def:12345678; ]
+		}
+	`
+
+	e, err := NewEdtd(bytes.NewBufferString(test))
+	require.Nil(t, err)
+
+	expect := map[elementID]int64{
+		0x4461: 1e9,
+		0x4462: 5e8,
+		0x4463: 86400e9,
+		0x4464: -5,
+		0x4465: 20010102,
+		0x4466: 86400e9,
+		0x4467: 12345678,
+	}
+	for id, i := range expect {
+		assert.Equal(t, mustDefDataBytes(i), e.elements[id].def, "id: %x", id)
+	}
+
+	// Compact dates with extended times aren't valid ISO-8601
+	_, err = NewEdtd(bytes.NewBufferString(`
+		define elements {
+			A := 4461 date [ def:"20010101T00:00:00"; ]
+		}
+	`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,82 @@
+package edtd
+
+import (
+	"fmt"
+	"io"
+	"iter"
+)
+
+// Returns an iterator over all remaining Elems in the stream, as returned by
+// Next(). Iteration stops once the stream ends cleanly, any other error is
+// yielded once before stopping. Breaking out of the loop early is safe, Next()
+// can be used afterwards to continue from where the loop left off.
+//
+//	for el, err := range p.All() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (p *Parser) All() iter.Seq2[*Elem, error] {
+	return func(yield func(*Elem, error) bool) {
+		for {
+			el, err := p.Next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			} else if !yield(el, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator over the direct children of the container Elem last
+// returned from Next(). Any child container which isn't iterated over itself
+// (using Children()) in the body of the loop has its children skipped, see
+// SkipChildren(). Once the iteration is done, the following call to Next()
+// returns the container's next sibling. If the loop is broken out of early
+// Next() continues from the child it was broken out of on.
+func (p *Parser) Children() iter.Seq2[*Elem, error] {
+	c := p.last
+	return func(yield func(*Elem, error) bool) {
+		if c == nil || c.Type != Container {
+			yield(nil, fmt.Errorf("last element is not a container"))
+			return
+		}
+		for {
+			el, err := p.Next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if nodeEnded(c, el) {
+				p.unread(c, el)
+				return
+			} else if el.Depth > c.Depth+1 {
+				// A descendant of a child which was only partly iterated over
+				if el.Type == Container {
+					if err := p.SkipChildren(); err != nil {
+						yield(nil, err)
+						return
+					}
+				}
+				continue
+			}
+
+			if !yield(el, nil) {
+				return
+			} else if p.last == el && el.Type == Container {
+				if err := p.SkipChildren(); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package edtd
+
+import (
+	"fmt"
+
+	"github.com/mediocregopher/ebmlstream"
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// Returns the id of the element with the given name in the edtd, which must be
+// able to appear inside of the container with the parent id: it's defined
+// inside of it, is global (e.g. Void), or is the container itself, since some
+// elements can be nested inside of themselves. For top-level lookups (a parent
+// of 0) any element can be found, as long as its name isn't used by more than
+// one. Implements ebmlstream.Schema
+func (e *Edtd) ElementID(parent varint.VarInt, name string) (varint.VarInt, error) {
+	var found, matched []*tplElement
+	for _, etpl := range e.elements {
+		if etpl.name != name {
+			continue
+		}
+		found = append(found, etpl)
+		if etpl.parent == elementID(parent) || etpl.global || etpl.id == elementID(parent) {
+			matched = append(matched, etpl)
+		}
+	}
+	if parent == 0 && len(found) == 1 {
+		matched = found
+	}
+
+	switch {
+	case len(matched) == 1:
+		return varint.VarInt(matched[0].id), nil
+	case len(matched) > 1:
+		return 0, fmt.Errorf(
+			"ambiguous element name %q, it's used by %d elements", name, len(matched),
+		)
+	case len(found) == 0 || parent == 0:
+		return 0, fmt.Errorf("%w: %q", ebmlstream.UnknownElement, name)
+	}
+	return 0, fmt.Errorf(
+		"%w: %q inside of %x", ebmlstream.UnknownElement, name, uint64(parent),
+	)
+}
+
+// Returns the encoded default data of the element with the given id, if it has
+// one in the edtd. Implements ebmlstream.Schema
+func (e *Edtd) DefaultData(id varint.VarInt) ([]byte, bool) {
+	etpl, ok := e.elements[elementID(id)]
+	if !ok || etpl.def == nil {
+		return nil, false
+	}
+	return etpl.def, true
+}
+
+// Like ebmlstream.Marshal, but struct tags can use the names of elements in the
+// edtd (e.g. `ebml:"EBMLVersion"`) as well as their ids
+func (e *Edtd) Marshal(v interface{}) ([]byte, error) {
+	return ebmlstream.MarshalSchema(v, e)
+}
+
+// Like ebmlstream.Unmarshal, but struct tags can use the names of elements in
+// the edtd (e.g. `ebml:"EBMLVersion"`) as well as their ids. Fields whose
+// elements aren't present are set to the element's default value from the
+// edtd, unless they're pointers or slices.
+func (e *Edtd) Unmarshal(b []byte, v interface{}) error {
+	return ebmlstream.UnmarshalSchema(b, v, e)
+}
@@ -0,0 +1,116 @@
+package edtd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "testing"
+)
+
+type testHeader struct {
+	Version     uint64  `ebml:"EBMLVersion"`
+	ReadVersion *uint64 `ebml:"EBMLReadVersion"`
+	DocType     string  `ebml:"DocType"`
+	MaxIDLength uint8   `ebml:"42f2"`
+}
+
+type testFile struct {
+	Header testHeader `ebml:"EBML"`
+}
+
+func TestMarshal(t *T) {
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+
+	b := []byte{
+		0x1a, 0x45, 0xdf, 0xa3, 0x8b,
+		0x42, 0x82, 0x88, 'm', 'a', 't', 'r', 'o', 's', 'k', 'a',
+	}
+	var f testFile
+	require.Nil(t, e.Unmarshal(b, &f))
+	assert.Equal(t, testHeader{
+		Version:     1,
+		DocType:     "matroska",
+		MaxIDLength: 4,
+	}, f.Header)
+
+	f.Header.DocType = "webm"
+	b, err = e.Marshal(f)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x1a, 0x45, 0xdf, 0xa3, 0x8f,
+		0x42, 0x86, 0x81, 0x01,
+		0x42, 0x82, 0x84, 'w', 'e', 'b', 'm',
+		0x42, 0xf2, 0x81, 0x04,
+	}, b)
+}
+
+func TestMarshalDuplicateNames(t *T) {
+	e, err := NewEdtd(bytes.NewBufferString(`
+		define elements {
+			A := 81 container {
+				Dup := 82 uint;
+			}
+			B := 83 container {
+				Dup := 84 uint;
+			}
+		}
+	`))
+	require.Nil(t, err)
+
+	type inA struct {
+		Dup uint8 `ebml:"Dup"`
+	}
+	type inB struct {
+		Dup uint8 `ebml:"Dup"`
+	}
+	v := struct {
+		A inA `ebml:"A"`
+		B inB `ebml:"B"`
+	}{inA{1}, inB{2}}
+
+	b, err := e.Marshal(v)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x81, 0x83, 0x82, 0x81, 0x01, 0x83, 0x83, 0x84, 0x81, 0x02}, b)
+
+	v.A, v.B = inA{}, inB{}
+	require.Nil(t, e.Unmarshal(b, &v))
+	assert.Equal(t, uint8(1), v.A.Dup)
+	assert.Equal(t, uint8(2), v.B.Dup)
+
+	// At the top-level it can't be known which Dup is meant
+	_, err = e.Marshal(inA{1})
+	assert.NotNil(t, err)
+}
+
+func TestMarshalWrongParent(t *T) {
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+
+	// Timecode is only defined inside of Cluster
+	var cues struct {
+		Cues struct {
+			T uint64 `ebml:"Timecode"`
+		} `ebml:"Cues"`
+	}
+	_, err = e.Marshal(cues)
+	assert.NotNil(t, err)
+
+	var cluster struct {
+		Cluster struct {
+			T uint64 `ebml:"Timecode"`
+			V []byte `ebml:"Void"`
+		} `ebml:"Cluster"`
+	}
+	b, err := e.Marshal(cluster)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x1f, 0x43, 0xb6, 0x75, 0x84, 0xe7, 0x80, 0xec, 0x80}, b)
+
+	// Top-level lookups can find elements defined anywhere
+	var timecode struct {
+		T uint64 `ebml:"Timecode"`
+	}
+	b, err = e.Marshal(timecode)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0xe7, 0x80}, b)
+}
@@ -0,0 +1,148 @@
+package edtd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mediocregopher/ebmlstream"
+)
+
+// Returns a new ebmlstream.Node for the Elem, with its Value filled in using
+// the data method for its Type. Containers are returned without children
+func newNode(el *Elem) (*ebmlstream.Node, error) {
+	n := &ebmlstream.Node{Id: el.Id}
+	var err error
+	switch el.Type {
+	case Int:
+		n.Value, err = el.Int()
+	case Uint:
+		n.Value, err = el.Uint()
+	case Float:
+		var f float64
+		if f, err = el.Float(); err != nil {
+			break
+		} else if size, _ := el.Size.Uint64(); size == 4 {
+			n.Value = float32(f)
+		} else {
+			n.Value = f
+		}
+	case String:
+		n.Value, err = el.Str()
+	case UTF8:
+		n.Value, err = el.UTF8()
+	case Date:
+		n.Value, err = el.Date()
+	case Binary:
+		n.Value, err = el.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Returns the Elem last returned from Next() as an ebmlstream.Node. The values
+// of non-container Nodes are filled in according to their type in the edtd
+// (e.g. uint64 for Uint elements, string for String and UTF8 elements). If the
+// Elem is a container all of its children are read in as well, so that the
+// following call to Next() returns the container's next sibling.
+//
+// Float elements of size 4 are given float32 values so that they're written
+// back out the same way, all others are given float64 values.
+func (p *Parser) Node() (*ebmlstream.Node, error) {
+	c := p.last
+	if c == nil {
+		return nil, fmt.Errorf("no element to read")
+	}
+	root, err := newNode(c)
+	if err != nil || c.Type != Container {
+		return root, err
+	}
+
+	type openNode struct {
+		*ebmlstream.Node
+		el *Elem
+	}
+	open := []openNode{{Node: root, el: c}}
+
+	for {
+		el, err := p.Next()
+		if err == io.EOF {
+			return root, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		for len(open) > 0 && nodeEnded(open[len(open)-1].el, el) {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			p.unread(c, el)
+			return root, nil
+		}
+
+		n, err := newNode(el)
+		if err != nil {
+			return nil, err
+		}
+		parent := open[len(open)-1]
+		parent.Children = append(parent.Children, n)
+		if el.Type == Container {
+			open = append(open, openNode{Node: n, el: el})
+		}
+	}
+}
+
+// Puts back el, which was read while reading the contents of the container c
+// but isn't inside of it, so that it's returned from the next call to Next().
+// Anything it ended inside the container was never returned, and so isn't
+// included in its Ends (or Ended)
+func (p *Parser) unread(c, el *Elem) {
+	n := 0
+	for n < len(el.Ends) && el.Ends[n] != c && el.Ends[n].Offset > c.Offset {
+		n++
+	}
+	el.trimEnds(n)
+	p.buffer.PushBack(el)
+	p.last = nil
+}
+
+// Drops the first n containers from the Elem's Ends, and from Ended along with
+// them
+func (el *Elem) trimEnds(n int) {
+	el.Ends = el.Ends[n:]
+	el.Ended = el.Ended[n:]
+}
+
+// Returns true if el comes after the end of the container c
+func nodeEnded(c, el *Elem) bool {
+	if end, ok := c.End(); ok {
+		return el.Offset >= end
+	}
+	for _, e := range el.Ends {
+		if e == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads all remaining elements in the stream into trees of ebmlstream.Nodes,
+// one for each element which isn't inside of another (see Node()). This starts
+// from the element after the one last returned from Next(), so on a new Parser
+// the whole stream is read
+func (p *Parser) ReadNodes() ([]*ebmlstream.Node, error) {
+	var nodes []*ebmlstream.Node
+	for {
+		if _, err := p.Next(); err == io.EOF {
+			return nodes, nil
+		} else if err != nil {
+			return nil, err
+		}
+		n, err := p.Node()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
@@ -2,87 +2,531 @@ package edtd
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/mediocregopher/ebmlstream"
+	"github.com/mediocregopher/ebmlstream/varint"
 )
 
 // Parsers are generated from an Edtd using NewParser. They return sequential
-// Elem structs which have their data already read in (meaning you DON'T have to
-// call a data method before calling Next() again, as in the root ebmlstream
-// package). See the example package for more on how to use the Parser
+// Elem structs whose data is read in lazily, the first time a data method is
+// called on them. Since the Parser knows which elements are containers you DON'T
+// have to call a data method before calling Next() again (as in the root
+// ebmlstream package), the data of any element which wasn't read is skipped.
+// See the example package for more on how to use the Parser.
+//
+// The EBMLMaxIDLength and EBMLMaxSizeLength elements of the EBML header are
+// always read by the Parser, and any element after them with a longer id or
+// size causes an ebmlstream.ParseError wrapping ebmlstream.VarIntTooLong. Until
+// they're read their defaults (4 and 8 bytes) are used.
 type Parser struct {
 	edtd     *Edtd
+	opts     ParserOptions
 	lastElem *ebmlstream.Elem
 	buffer   *list.List
+
+	// the last Elem returned from Next, if any
+	last *Elem
+
+	// Containers which haven't been ended yet, so that they can be given in
+	// Ends. Which containers are open is tracked by the stream (see
+	// ebmlstream.Elem's Parent and Ended), this only holds the Elems the
+	// Parser returned for them
+	containers map[*ebmlstream.Elem]*Elem
+
+	// set when the stream has just been resynchronized, see recover
+	resynced bool
+	skipped  []Skipped
+
+	// The longest ids and sizes allowed, from the EBML header
+	maxIDLength, maxSizeLength int
+
+	// Set if the Parser was created with NewParserContext
+	ctx context.Context
 }
 
+// ParserOptions are used to change the behavior of a Parser, see
+// NewParserOptions. The zero value is the default behavior.
+type ParserOptions struct {
+	// Limits on the resources used while reading the stream, see
+	// ebmlstream.ReaderOptions. Errors caused by limits are never recovered
+	// from, even if Recover is set
+	ebmlstream.ReaderOptions
+
+	// If true the Parser will attempt to recover from corrupt data rather than
+	// returning an error. When an element can't be read because of an invalid
+	// varint, an unknown id, or it violating the schema, the Parser scans
+	// forward for the next level 0 or level 1 element in the edtd (e.g.
+	// Cluster or Cues in matroska) and resumes from there. The ranges of bytes
+	// skipped over can be retrieved using Skipped().
+	Recover bool
+
+	// If true the contents of each CRC32 container (of known size) are checked
+	// against the CRC32Value inside it, which must be the little-endian IEEE
+	// CRC-32 of all the container's other children. A mismatch is returned from
+	// Next() as an ebmlstream.ParseError wrapping ebmlstream.CRCMismatch, once
+	// the end of the container is reached, and a container without a 4 byte
+	// CRC32Value as one wrapping ebmlstream.SchemaViolation. A container
+	// skipped with SkipChildren() isn't checked. See ebmlstream.Elem's
+	// VerifyCRC32.
+	VerifyCRC32 bool
+
+	// If set, CRC-32 mismatches are passed to this instead of being returned
+	// from Next(), allowing them to be treated as warnings
+	OnCRC32Mismatch func(error)
+
+	// If true Void elements aren't returned from Next(), their data is skipped
+	// over without being read (or seeked past, if the io.Reader supports it)
+	SkipVoid bool
+}
+
+// Skipped describes a range of bytes in the stream which the Parser skipped
+// over while recovering from corrupt data
+type Skipped struct {
+	// The range of bytes skipped, Start inclusive and End exclusive
+	Start, End int64
+
+	// The error which caused the bytes to be skipped
+	Cause error
+}
+
+// The ids of the EBMLMaxIDLength and EBMLMaxSizeLength elements, and their
+// defaults, from implicitElements
+const (
+	maxIDLengthID        = elementID(0x42f2)
+	maxSizeLengthID      = elementID(0x42f3)
+	defaultMaxIDLength   = 4
+	defaultMaxSizeLength = 8
+)
+
 // Represents a single ebml element. It contains the base ebmlstream.Elem this
-// is based on (with the data for that element having already been read into
-// it), as well as some extra information from the edtd
+// is based on, as well as some extra information from the edtd. The data
+// methods on the Elem can only be called (for the first time) before the next
+// call to Next() on the Parser, after which they will return
+// ebmlstream.DataUnavailable
 type Elem struct {
-	ebmlstream.Elem
+	*ebmlstream.Elem
 	Type
 	Name  string
 
 	// The heirarchical level of the edtd this element appears on. Starts at 0
-	// and goes up from there
+	// and goes up from there. The level the element actually appears on in
+	// the stream is given by Depth (and its container by Parent), in the
+	// embedded ebmlstream.Elem
 	Level uint64
+
+	// Containers of unknown size (see ebmlstream.Elem) have no set end in the
+	// stream, they are ended by the first element which cannot be their child,
+	// or along with a container of known size they're inside of. Ends holds
+	// the same containers as Ended, in the embedded ebmlstream.Elem, but as the
+	// Elems which were returned for them, innermost first.
+	Ends []*Elem
 }
 
 // Returns a new parser for the edtd which will read from the io.Reader and
 // return Elems
 func (e *Edtd) NewParser(r io.Reader) *Parser {
-	return &Parser{
-		edtd:     e,
-		lastElem: ebmlstream.RootElem(r),
-		buffer:   list.New(),
+	return e.NewParserOptions(r, ParserOptions{})
+}
+
+// Like NewParser, but the returned Parser will use the given options
+func (e *Edtd) NewParserOptions(r io.Reader, opts ParserOptions) *Parser {
+	p := &Parser{
+		edtd:          e,
+		opts:          opts,
+		lastElem:      ebmlstream.RootElemOptions(r, opts.ReaderOptions),
+		buffer:        list.New(),
+		containers:    map[*ebmlstream.Elem]*Elem{},
+		maxIDLength:   defaultMaxIDLength,
+		maxSizeLength: defaultMaxSizeLength,
+	}
+	p.lastElem.EndFunc(p.endsContainer)
+	if opts.VerifyCRC32 {
+		p.lastElem.VerifyCRC32()
+	}
+	return p
+}
+
+// Like NewParserOptions, but the returned Parser stops once the context is
+// done. Next(), and the data methods of the Elems it returns, will return the
+// context's error (ctx.Err()) from then on. The context is checked between
+// reads from the io.Reader, so this works even in the middle of reading a large
+// element, but a read which is blocked can't be interrupted (closing the
+// io.Reader, if possible, usually will though).
+func (e *Edtd) NewParserContext(
+	ctx context.Context, r io.Reader, opts ParserOptions,
+) *Parser {
+	p := e.NewParserOptions(r, opts)
+	p.ctx = ctx
+	p.lastElem.SetContext(ctx)
+	return p
+}
+
+// Used as the ebmlstream.Elem EndFunc. Containers of unknown size are ended by
+// the first element which is on the same or a lower level in the edtd, unless
+// it's global (e.g. Void) and so can be the child of anything. Just after the
+// stream has been resynchronized containers of known size are ended in the same
+// way, since whatever was open when the corrupt data was found can't be
+// trusted. Only the Parser decides which elements are containers, so anything
+// else the stream thinks is one is ended straight away.
+func (p *Parser) endsContainer(c, next *ebmlstream.Elem) bool {
+	ctpl, ok := p.edtd.elements[elementID(c.Id)]
+	if !ok || ctpl.typ != Container {
+		return true
 	}
+	etpl, ok := p.edtd.elements[elementID(next.Id)]
+	if !ok {
+		return false
+	} else if p.resynced {
+		return ctpl.level >= etpl.level
+	}
+	return c.UnknownSize && !etpl.global && ctpl.level >= etpl.level
 }
 
 // Returns the next ebml element in the stream. It is NOT necessary to call a
 // data method on the Elem before calling Next() again (as it is in the base
-// ebmlstream package)
+// ebmlstream package), if none was called the Elem's data is skipped over
 func (p *Parser) Next() (*Elem, error) {
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
 	if f := p.buffer.Front(); f != nil {
-		return p.buffer.Remove(f).(*Elem), nil
+		p.last = p.buffer.Remove(f).(*Elem)
+		return p.last, nil
 	}
 
-	e, err := p.lastElem.Next()
+	if p.last != nil && p.last.Type != Container {
+		if err := p.last.Skip(); err != nil {
+			return nil, p.withPath(err)
+		}
+	}
+
+	el, err := p.read()
+	for err != nil && p.opts.Recover && p.recoverable(err) {
+		el, err = p.recover(err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	p.last = el
+	return el, nil
+}
 
-	etpl, ok := p.edtd.elements[elementID(e.Id)]
-	if !ok {
-		return nil, fmt.Errorf("unknown id: %x", e.Id)
-	}
-
-	switch etpl.typ {
-	case Int:
-		_, err = e.Int()
-	case Uint:
-		_, err = e.Uint()
-	case Float:
-		_, err = e.Float()
-	case Date:
-		_, err = e.Date()
-	case String:
-		_, err = e.Str()
-	case Binary:
-		_, err = e.Bytes()
+// Errors about an element which has already been returned, e.g. a CRC32
+// container which is only verified once it has ended, aren't recovered from,
+// since there's nothing left to skip over
+func (p *Parser) recoverable(err error) bool {
+	var perr *ebmlstream.ParseError
+	if errors.As(err, &perr) && perr.Offset < p.lastElem.Offset {
+		return false
 	}
-	if err != nil {
+	return errors.Is(err, varint.InvalidVarInt) ||
+		errors.Is(err, ebmlstream.VarIntTooLong) ||
+		errors.Is(err, ebmlstream.UnknownElement) ||
+		errors.Is(err, ebmlstream.SchemaViolation)
+}
+
+// Returns true if the element is on level 0 or 1 of the edtd. These are
+// considered safe places to resume parsing from when recovering
+func (p *Parser) isSyncPoint(id varint.VarInt) bool {
+	etpl, ok := p.edtd.elements[elementID(id)]
+	return ok && !etpl.global && etpl.level <= 1
+}
+
+// Scans forward from the error to the next sync point and reads the element
+// there. The error must be an ebmlstream.ParseError
+func (p *Parser) recover(err error) (*Elem, error) {
+	var perr *ebmlstream.ParseError
+	if !errors.As(err, &perr) {
+		return nil, err
+	}
+
+	end, rerr := p.lastElem.Resync(p.isSyncPoint)
+	if rerr == io.EOF {
+		return nil, err
+	} else if rerr != nil {
+		return nil, rerr
+	}
+
+	p.skipped = append(p.skipped, Skipped{
+		Start: perr.Offset,
+		End:   end,
+		Cause: err,
+	})
+	p.resynced = true
+	return p.read()
+}
+
+// Returns all ranges of bytes which have been skipped over so far while
+// recovering from corrupt data (see ParserOptions), in the order they appeared
+// in the stream
+func (p *Parser) Skipped() []Skipped {
+	return p.skipped
+}
+
+// Reads the next element header off the stream and matches it to its edtd
+// element
+func (p *Parser) read() (*Elem, error) {
+	e, err := p.lastElem.Next()
+	p.resynced = false
+	if errors.Is(err, ebmlstream.CRCMismatch) && p.opts.OnCRC32Mismatch != nil {
+		p.opts.OnCRC32Mismatch(p.withPath(err))
+		return p.read()
+	} else if err != nil {
+		return nil, p.withPath(err)
+	}
+	ends := p.ended(e)
+	if err := p.checkLengths(e); err != nil {
 		return nil, err
 	}
 
+	etpl, ok := p.edtd.elements[elementID(e.Id)]
+	if !ok {
+		return nil, p.parseErr(e, ebmlstream.UnknownElement)
+	}
+
 	p.lastElem = e
 
-	return &Elem{
-		Elem:  *e,
+	el := &Elem{
+		Elem:  e,
 		Type:  etpl.typ,
 		Name:  etpl.name,
 		Level: etpl.level,
-	}, nil
+		Ends:  ends,
+	}
+	if err := p.checkSchema(el); err != nil {
+		return nil, err
+	}
+
+	if etpl.id == maxIDLengthID || etpl.id == maxSizeLengthID {
+		if err := p.readLengthLimit(el); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.opts.SkipVoid && el.IsVoid() {
+		if err := el.Skip(); err != nil {
+			return nil, p.withPath(err)
+		}
+		return p.read()
+	}
+
+	if el.Type == Container {
+		p.containers[e] = el
+	}
+	return el, nil
+}
+
+// Called with each element read off the stream, to forget about the
+// containers it ended. The Elems which were returned for the containers are
+// returned, to be used as the element's Ends
+func (p *Parser) ended(e *ebmlstream.Elem) []*Elem {
+	var ends []*Elem
+	for _, c := range e.Ended {
+		el, ok := p.containers[c]
+		if !ok {
+			// Something the stream took to be a container but the Parser
+			// didn't, see endsContainer
+			el = &Elem{Elem: c, Name: p.name(c)}
+		}
+		delete(p.containers, c)
+		ends = append(ends, el)
+	}
+	return ends
+}
+
+// Makes sure the element's id and size are no longer than the EBML header
+// allows
+func (p *Parser) checkLengths(e *ebmlstream.Elem) error {
+	idLength, err := e.Id.Size()
+	if err != nil {
+		return p.parseErr(e, fmt.Errorf(
+			"%w: %w", ebmlstream.VarIntTooLong, err,
+		))
+	}
+	sizeLength := e.HeaderSize - idLength
+
+	var cause error
+	if idLength > p.maxIDLength {
+		cause = fmt.Errorf(
+			"%w: id is %d bytes, EBMLMaxIDLength is %d",
+			ebmlstream.VarIntTooLong, idLength, p.maxIDLength,
+		)
+	} else if sizeLength > p.maxSizeLength {
+		cause = fmt.Errorf(
+			"%w: size is %d bytes, EBMLMaxSizeLength is %d",
+			ebmlstream.VarIntTooLong, sizeLength, p.maxSizeLength,
+		)
+	}
+
+	if cause == nil {
+		return nil
+	}
+	return p.parseErr(e, cause)
+}
+
+// Reads the value of an EBMLMaxIDLength or EBMLMaxSizeLength element, and
+// applies it to all elements read after it. Ids can't be shorter than 4 bytes
+// by default, and neither can be longer than 8 bytes, since that's as long as
+// a varint can be
+func (p *Parser) readLengthLimit(el *Elem) error {
+	l, err := el.Uint()
+	var serr *ebmlstream.SizeError
+	if errors.As(err, &serr) {
+		return p.parseErr(el.Elem, fmt.Errorf(
+			"%w: %w", ebmlstream.SchemaViolation, err,
+		))
+	} else if err != nil {
+		return p.withPath(err)
+	}
+
+	min := uint64(1)
+	if elementID(el.Id) == maxIDLengthID {
+		min = defaultMaxIDLength
+	}
+	if l < min || l > 8 {
+		return p.parseErr(el.Elem, fmt.Errorf(
+			"%w: %s of %d is outside of %d..8",
+			ebmlstream.SchemaViolation, el.Name, l, min,
+		))
+	}
+
+	if elementID(el.Id) == maxIDLengthID {
+		p.maxIDLength = int(l)
+	} else {
+		p.maxSizeLength = int(l)
+	}
+	return nil
+}
+
+// Returns the name of the element in the edtd, or its id in hex if it isn't in
+// the edtd
+func (p *Parser) name(e *ebmlstream.Elem) string {
+	if etpl, ok := p.edtd.elements[elementID(e.Id)]; ok {
+		return etpl.name
+	}
+	return fmt.Sprintf("%x", uint64(e.Id))
+}
+
+// Returns the names of the containers the element is inside of, outermost
+// first
+func (p *Parser) elemPath(e *ebmlstream.Elem) []string {
+	var path []string
+	for c := e.Parent; c != nil; c = c.Parent {
+		path = append([]string{p.name(c)}, path...)
+	}
+	return path
+}
+
+// Returns a ParseError for a problem with the element, which was read
+func (p *Parser) parseErr(e *ebmlstream.Elem, cause error) error {
+	return &ebmlstream.ParseError{
+		Offset:    e.Offset,
+		ElementID: e.Id,
+		Path:      p.elemPath(e),
+		Cause:     cause,
+	}
+}
+
+// Returns the names of the containers the element at the given offset is
+// inside of, outermost first, for when the element itself couldn't be read (or
+// is the last one read). These are the containers the last element read is
+// inside of, and that element itself if it's a container, minus any which
+// don't hold the offset.
+func (p *Parser) pathAt(offset int64) []string {
+	var open []*ebmlstream.Elem
+	if etpl, ok := p.edtd.elements[elementID(p.lastElem.Id)]; ok && etpl.typ == Container {
+		open = append(open, p.lastElem)
+	}
+	for c := p.lastElem.Parent; c != nil; c = c.Parent {
+		open = append(open, c)
+	}
+
+	var path []string
+	for i := len(open) - 1; i >= 0; i-- {
+		if end, ok := open[i].End(); offset < open[i].DataOffset || (ok && offset >= end) {
+			break
+		}
+		path = append(path, p.name(open[i]))
+	}
+	return path
+}
+
+// If the error is an ebmlstream.ParseError fills in its Path
+func (p *Parser) withPath(err error) error {
+	var perr *ebmlstream.ParseError
+	if errors.As(err, &perr) && perr.Path == nil {
+		perr.Path = p.pathAt(perr.Offset)
+	}
+	return err
+}
+
+// Makes sure that the element doesn't go past the end of its parent. An
+// element of unknown size inside of a parent of known size is fine, it ends
+// along with its parent.
+//
+// The level of the element in the edtd isn't compared to how many containers
+// it's in, since some elements (e.g. SimpleTag in matroska) can be nested
+// inside of themselves, which the edtd has no way of saying.
+func (p *Parser) checkSchema(el *Elem) error {
+	if el.Parent == nil || el.UnknownSize {
+		return nil
+	}
+	end, ok := el.Parent.End()
+	if elEnd, _ := el.End(); !ok || elEnd <= end {
+		return nil
+	}
+	return p.parseErr(el.Elem, fmt.Errorf(
+		"%w: %s extends past the end of %s",
+		ebmlstream.SchemaViolation, el.Name, p.name(el.Parent),
+	))
+}
+
+// Skips over all the children of the container Elem which was just returned
+// from Next(), so that the following call to Next() returns the container's
+// next sibling. If the container's size is known this is done using
+// ebmlstream.Elem's Skip(), so nothing in the container is read. If it's of
+// unknown size then each child's header must still be read in order to find
+// the end of the container, but their data is skipped.
+func (p *Parser) SkipChildren() error {
+	c := p.last
+	if c == nil || c.Type != Container {
+		return fmt.Errorf("last element is not a container")
+	} else if !c.UnknownSize {
+		return c.Skip()
+	}
+
+	// Make sure SkipChildren can't be called on this container again
+	p.last = nil
+	for {
+		el, err := p.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Once the container has been ended the element which ended it is its
+		// next sibling, which needs to be returned from Next(). Anything ended
+		// inside the container was never returned, and so isn't included
+		for i := range el.Ends {
+			if el.Ends[i] == c {
+				el.trimEnds(i)
+				p.buffer.PushBack(el)
+				return nil
+			}
+		}
+
+		if el.Type != Container || !el.UnknownSize {
+			if err := el.Skip(); err != nil {
+				return err
+			}
+		}
+	}
 }
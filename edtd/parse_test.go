@@ -0,0 +1,651 @@
+package edtd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	. "testing"
+
+	"github.com/mediocregopher/ebmlstream"
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+var testParseEdtd = `
+	define elements {
+		Segment := 18538067 container [ card:*; ] {
+			Cluster := 1f43b675 container [ card:*; ] {
+				Timecode := e7 uint;
+				SimpleBlock := a3 binary;
+			}
+			Cues := 1c53bb6b container;
+		}
+	}
+`
+
+func testParser(t *T, b []byte) *Parser {
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	return e.NewParser(bytes.NewBuffer(b))
+}
+
+func TestParseUnknownSize(t *T) {
+	unknown := []byte{0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67)
+	b = append(b, unknown...)
+	for i := 0; i < 2; i++ {
+		b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+		b = append(b, 0xe7, 0x81, byte(i))
+		b = append(b, 0xec, 0x80)
+		b = append(b, 0xa3, 0x82, 0x01, 0x02)
+	}
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	p := testParser(t, b)
+	expect := []struct {
+		name  string
+		ends  []string
+		depth int
+	}{
+		{"Segment", nil, 0},
+		{"Cluster", nil, 1},
+		{"Timecode", nil, 2},
+		{"Void", nil, 2},
+		{"SimpleBlock", nil, 2},
+		{"Cluster", []string{"Cluster"}, 1},
+		{"Timecode", nil, 2},
+		{"Void", nil, 2},
+		{"SimpleBlock", nil, 2},
+		{"Cues", []string{"Cluster"}, 1},
+	}
+
+	for i := range expect {
+		el, err := p.Next()
+		require.Nil(t, err, "elem: %d", i)
+		assert.Equal(t, expect[i].name, el.Name, "elem: %d", i)
+		assert.Equal(t, expect[i].depth, el.Depth, "elem: %d", i)
+
+		var ends []string
+		for _, end := range el.Ends {
+			ends = append(ends, end.Name)
+		}
+		assert.Equal(t, expect[i].ends, ends, "elem: %d", i)
+	}
+}
+
+func TestParseUnknownSizeInSized(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0x88)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x81, 0x80)
+
+	p := testParser(t, b)
+	for i := 0; i < 3; i++ {
+		_, err := p.Next()
+		require.Nil(t, err)
+	}
+
+	// The first Segment ends, taking the Cluster inside of it along with it
+	el, err := p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "Segment", el.Name)
+	assert.Equal(t, 0, el.Depth)
+	require.Len(t, el.Ends, 2)
+	assert.Equal(t, "Cluster", el.Ends[0].Name)
+	assert.Equal(t, "Segment", el.Ends[1].Name)
+	require.Len(t, el.Ended, 2)
+	for i := range el.Ends {
+		assert.True(t, el.Ends[i].Elem == el.Ended[i])
+	}
+
+	_, err = p.Next()
+	var perr *ebmlstream.ParseError
+	require.True(t, errors.As(err, &perr), "err: %v", err)
+	assert.Equal(t, []string{"Segment"}, perr.Path)
+}
+
+func TestParseLazy(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x8b)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0xa3, 0x83, 0x01, 0x02, 0x03)
+	b = append(b, 0xa3, 0x81, 0x04)
+
+	p := testParser(t, b)
+	for i := 0; i < 2; i++ {
+		_, err := p.Next()
+		require.Nil(t, err)
+	}
+
+	// Timecode is read, the first SimpleBlock isn't and so should be skipped
+	el, err := p.Next()
+	require.Nil(t, err)
+	i, err := el.Uint()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), i)
+
+	skipped, err := p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "SimpleBlock", skipped.Name)
+
+	el, err = p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "SimpleBlock", el.Name)
+	bs, err := el.Bytes()
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x04}, bs)
+
+	_, err = skipped.Bytes()
+	assert.Equal(t, ebmlstream.DataUnavailable, err)
+}
+
+func TestParseSkipChildren(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	for _, unknown := range []bool{false, true} {
+		b = append(b, 0x1f, 0x43, 0xb6, 0x75)
+		if unknown {
+			b = append(b, 0xff)
+		} else {
+			b = append(b, 0x87)
+		}
+		b = append(b, 0xe7, 0x81, 0x01)
+		b = append(b, 0xa3, 0x82, 0x01, 0x02)
+	}
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	p := testParser(t, b)
+	el, err := p.Next()
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		el, err = p.Next()
+		require.Nil(t, err)
+		require.Equal(t, "Cluster", el.Name)
+		require.Nil(t, p.SkipChildren())
+	}
+
+	el, err = p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "Cues", el.Name)
+	require.Len(t, el.Ends, 1)
+	assert.Equal(t, "Cluster", el.Ends[0].Name)
+
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestParseErrors(t *T) {
+	segment := []byte{0x18, 0x53, 0x80, 0x67, 0x8a}
+	m := map[string]struct {
+		cause error
+		path  []string
+	}{
+		// Unknown id inside of a Cluster
+		string(append(segment, 0x1f, 0x43, 0xb6, 0x75, 0x83, 0x81, 0x81, 0x01)): {
+			ebmlstream.UnknownElement, []string{"Segment", "Cluster"},
+		},
+		// Cluster extending past the end of Segment
+		string(append(segment, 0x1f, 0x43, 0xb6, 0x75, 0x86)): {
+			ebmlstream.SchemaViolation, []string{"Segment"},
+		},
+		// Truncated Timecode
+		string([]byte{
+			0x18, 0x53, 0x80, 0x67, 0xff, 0x1f, 0x43, 0xb6, 0x75, 0xff,
+			0xe7, 0x82, 0x01,
+		}): {
+			ebmlstream.Truncated, []string{"Segment", "Cluster"},
+		},
+		// Cluster ending before its size says, after a full Timecode
+		string([]byte{
+			0x18, 0x53, 0x80, 0x67, 0xff, 0x1f, 0x43, 0xb6, 0x75, 0xa0,
+			0xe7, 0x81, 0x01,
+		}): {
+			ebmlstream.Truncated, []string{"Segment"},
+		},
+		// Invalid size varint
+		string(append(segment, 0x1f, 0x43, 0xb6, 0x75, 0x00)): {
+			varint.InvalidVarInt, []string{"Segment"},
+		},
+	}
+
+	for in, out := range m {
+		p := testParser(t, []byte(in))
+		var err error
+		for err == nil {
+			_, err = p.Next()
+		}
+
+		var perr *ebmlstream.ParseError
+		require.True(t, errors.As(err, &perr), "input: %x, err: %v", in, err)
+		assert.True(t, errors.Is(err, out.cause), "input: %x, err: %v", in, err)
+		assert.Equal(t, out.path, perr.Path, "input: %x", in)
+	}
+}
+
+func TestParseSchemaDepth(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0x95)
+	// A Cluster nested inside of itself, which the edtd can't express but
+	// which isn't rejected
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x88)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x83)
+	b = append(b, 0xe7, 0x81, 0x01)
+	// A live Cluster inside of a Segment of known size, which ends along with
+	// the Segment
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x02)
+
+	p := testParser(t, b)
+	var names []string
+	var depths []int
+	for {
+		el, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		names = append(names, el.Name)
+		depths = append(depths, el.Depth)
+	}
+	assert.Equal(t, []string{
+		"Segment", "Cluster", "Cluster", "Timecode", "Cluster", "Timecode",
+	}, names)
+	assert.Equal(t, []int{0, 1, 2, 3, 1, 2}, depths)
+}
+
+func TestParseRecover(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x01)
+	corruptAt := int64(len(b))
+	b = append(b, 0x00, 0x00, 0x12)
+	resumeAt := int64(len(b))
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x02)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+
+	// Without Recover the corrupt data is an error
+	p := e.NewParser(bytes.NewBuffer(b))
+	for err == nil {
+		_, err = p.Next()
+	}
+	assert.True(t, errors.Is(err, varint.InvalidVarInt))
+
+	p = e.NewParserOptions(bytes.NewBuffer(b), ParserOptions{Recover: true})
+	var names []string
+	for {
+		el, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		names = append(names, el.Name)
+	}
+
+	assert.Equal(t, []string{
+		"Segment", "Cluster", "Timecode", "Cluster", "Timecode", "Cues",
+	}, names)
+
+	skipped := p.Skipped()
+	require.Len(t, skipped, 1)
+	assert.Equal(t, corruptAt, skipped[0].Start)
+	assert.Equal(t, resumeAt, skipped[0].End)
+	assert.True(t, errors.Is(skipped[0].Cause, varint.InvalidVarInt))
+}
+
+func TestParseRecoverDepth(t *T) {
+	// The first Cluster's size claims the second Cluster is inside of it, but
+	// the first Cluster's data is corrupt so it can't be trusted
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x90)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0x00, 0x00, 0x12)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x02)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	p := e.NewParserOptions(bytes.NewBuffer(b), ParserOptions{Recover: true})
+
+	expect := []struct {
+		name   string
+		depth  int
+		parent string
+		ends   []string
+	}{
+		{"Segment", 0, "", nil},
+		{"Cluster", 1, "Segment", nil},
+		{"Timecode", 2, "Cluster", nil},
+		{"Cluster", 1, "Segment", []string{"Cluster"}},
+		{"Timecode", 2, "Cluster", nil},
+		{"Cues", 1, "Segment", []string{"Cluster"}},
+	}
+	for i, x := range expect {
+		el, err := p.Next()
+		require.Nil(t, err, "elem: %d", i)
+		assert.Equal(t, x.name, el.Name, "elem: %d", i)
+		assert.Equal(t, x.depth, el.Depth, "elem: %d", i)
+		if x.parent == "" {
+			assert.Nil(t, el.Parent, "elem: %d", i)
+		} else {
+			require.NotNil(t, el.Parent, "elem: %d", i)
+			assert.Equal(t, x.parent, p.name(el.Parent), "elem: %d", i)
+		}
+		var ends []string
+		for _, end := range el.Ends {
+			ends = append(ends, end.Name)
+		}
+		assert.Equal(t, x.ends, ends, "elem: %d", i)
+		assert.Len(t, el.Ended, len(el.Ends), "elem: %d", i)
+	}
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestParseCRC32(t *T) {
+	buf := new(bytes.Buffer)
+	enc := ebmlstream.NewStreamEncoder(buf)
+	enc.CRC32(0x1f43b675)
+	require.Nil(t, enc.StartContainer(0x18538067))
+	require.Nil(t, enc.StartContainer(0x1f43b675))
+	require.Nil(t, enc.PutUint(0xe7, 5))
+	require.Nil(t, enc.PutBinary(0xa3, []byte{1, 2, 3}))
+	require.Nil(t, enc.EndContainer())
+	require.Nil(t, enc.EndContainer())
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	opts := ParserOptions{VerifyCRC32: true}
+
+	parseAll := func(b []byte, opts ParserOptions) ([]string, error) {
+		p := e.NewParserOptions(bytes.NewBuffer(b), opts)
+		var names []string
+		for {
+			el, err := p.Next()
+			if err == io.EOF {
+				return names, nil
+			} else if err != nil {
+				return names, err
+			}
+			names = append(names, el.Name)
+		}
+	}
+
+	names, err := parseAll(buf.Bytes(), opts)
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"Segment", "Cluster", "CRC32", "Timecode", "SimpleBlock", "CRC32Value",
+	}, names)
+
+	// Change the Timecode's value
+	corrupt := append([]byte{}, buf.Bytes()...)
+	i := bytes.Index(corrupt, []byte{0xe7, 0x81, 0x05})
+	require.True(t, i > 0)
+	corrupt[i+2] = 0x06
+
+	_, err = parseAll(corrupt, opts)
+	assert.True(t, errors.Is(err, ebmlstream.CRCMismatch), "err: %v", err)
+	var perr *ebmlstream.ParseError
+	require.True(t, errors.As(err, &perr))
+	assert.Equal(t, []string{"Segment", "Cluster"}, perr.Path)
+
+	// Shorten the CRC32Value to 2 bytes
+	short := append([]byte{}, buf.Bytes()...)
+	i = bytes.Index(short, []byte{0x42, 0xfe, 0x84})
+	require.True(t, i > 0)
+	short[i+2] = 0x82
+	short = short[:len(short)-2]
+	c := bytes.Index(short, []byte{0xc3})
+	require.True(t, c > 0 && c < i)
+	short[c+1] -= 2
+
+	_, err = parseAll(short, opts)
+	assert.True(t, errors.Is(err, ebmlstream.SchemaViolation), "err: %v", err)
+
+	var warnings []error
+	opts.OnCRC32Mismatch = func(err error) { warnings = append(warnings, err) }
+	names, err = parseAll(corrupt, opts)
+	require.Nil(t, err)
+	assert.Len(t, names, 6)
+	assert.Len(t, warnings, 1)
+}
+
+func TestParseSkipVoid(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x8b)
+	b = append(b, 0xec, 0x82, 0x00, 0x00)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0xec, 0x80)
+	b = append(b, 0xec, 0x80)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	p := e.NewParserOptions(bytes.NewReader(b), ParserOptions{SkipVoid: true})
+
+	var names []string
+	for {
+		el, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		names = append(names, el.Name)
+	}
+	assert.Equal(t, []string{"Segment", "Cluster", "Timecode", "Cues"}, names)
+}
+
+func TestParseNode(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0xa3, 0x82, 0x01, 0x02)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x83)
+	b = append(b, 0xe7, 0x81, 0x02)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	p := testParser(t, b)
+	el, err := p.Next()
+	require.Nil(t, err)
+	el, err = p.Next()
+	require.Nil(t, err)
+	require.Equal(t, "Cluster", el.Name)
+
+	n, err := p.Node()
+	require.Nil(t, err)
+	require.Len(t, n.Children, 2)
+	assert.Equal(t, uint64(1), n.Child(0xe7).Value)
+	assert.Equal(t, []byte{1, 2}, n.Child(0xa3).Value)
+
+	el, err = p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "Cluster", el.Name)
+	require.Len(t, el.Ends, 1)
+	assert.Equal(t, "Cluster", el.Ends[0].Name)
+
+	nodes, err := p.ReadNodes()
+	require.Nil(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, uint64(2), nodes[0].Value)
+	assert.Equal(t, varint.VarInt(0x1c53bb6b), nodes[1].Id)
+
+	// Change the timecode and write the Cluster back out with its new size
+	n.Child(0xe7).Value = uint64(0x1234)
+	buf := new(bytes.Buffer)
+	_, err = n.WriteTo(buf)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{
+		0x1f, 0x43, 0xb6, 0x75, 0x88,
+		0xe7, 0x82, 0x12, 0x34,
+		0xa3, 0x82, 0x01, 0x02,
+	}, buf.Bytes())
+}
+
+func TestParseIter(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x01)
+	b = append(b, 0xa3, 0x82, 0x01, 0x02)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0x83)
+	b = append(b, 0xe7, 0x81, 0x02)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x80)
+
+	var names []string
+	for el, err := range testParser(t, b).All() {
+		require.Nil(t, err)
+		names = append(names, el.Name)
+	}
+	assert.Equal(t, []string{
+		"Segment", "Cluster", "Timecode", "SimpleBlock",
+		"Cluster", "Timecode", "Cues",
+	}, names)
+
+	p := testParser(t, b)
+	_, err := p.Next()
+	require.Nil(t, err)
+	names = nil
+	var timecodes []uint64
+	for el, err := range p.Children() {
+		require.Nil(t, err)
+		names = append(names, el.Name)
+		if len(names) == 1 {
+			// Only iterate into the first Cluster
+			for child, err := range p.Children() {
+				require.Nil(t, err)
+				if child.Name == "Timecode" {
+					tc, err := child.Uint()
+					require.Nil(t, err)
+					timecodes = append(timecodes, tc)
+				}
+			}
+		}
+	}
+	assert.Equal(t, []string{"Cluster", "Cluster", "Cues"}, names)
+	assert.Equal(t, []uint64{1}, timecodes)
+
+	// Breaking out early
+	p = testParser(t, b)
+	for _, err := range p.All() {
+		require.Nil(t, err)
+		break
+	}
+	el, err := p.Next()
+	require.Nil(t, err)
+	assert.Equal(t, "Cluster", el.Name)
+}
+
+func TestParseLengthLimits(t *T) {
+	header := []byte{
+		0x1a, 0x45, 0xdf, 0xa3, 0x84,
+		0x42, 0xf3, 0x81, 0x02,
+	}
+
+	var b []byte
+	b = append(b, header...)
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0x7f, 0xff)
+	b = append(b, 0x1c, 0x53, 0xbb, 0x6b, 0x20, 0x00, 0x00)
+
+	p := testParser(t, b)
+	for i := 0; i < 3; i++ {
+		_, err := p.Next()
+		require.Nil(t, err)
+	}
+	_, err := p.Next()
+	assert.True(t, errors.Is(err, ebmlstream.VarIntTooLong), "err: %v", err)
+
+	// The default EBMLMaxIDLength of 4 applies without a header
+	p = testParser(t, []byte{0x08, 0x01, 0x02, 0x03, 0x04, 0x80})
+	_, err = p.Next()
+	assert.True(t, errors.Is(err, ebmlstream.VarIntTooLong), "err: %v", err)
+
+	p = testParser(t, []byte{
+		0x1a, 0x45, 0xdf, 0xa3, 0x84,
+		0x42, 0xf2, 0x81, 0x09,
+	})
+	_, err = p.Next()
+	require.Nil(t, err)
+	_, err = p.Next()
+	assert.True(t, errors.Is(err, ebmlstream.SchemaViolation), "err: %v", err)
+
+	// A value too big to be a uint at all
+	p = testParser(t, []byte{
+		0x1a, 0x45, 0xdf, 0xa3, 0x8c,
+		0x42, 0xf3, 0x89, 0, 0, 0, 0, 0, 0, 0, 0, 0x04,
+	})
+	_, err = p.Next()
+	require.Nil(t, err)
+	_, err = p.Next()
+	var perr *ebmlstream.ParseError
+	require.True(t, errors.As(err, &perr), "err: %v", err)
+	assert.True(t, errors.Is(err, ebmlstream.SchemaViolation), "err: %v", err)
+	assert.Equal(t, int64(5), perr.Offset)
+	assert.Equal(t, []string{"EBML"}, perr.Path)
+}
+
+func TestParseLimits(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xe7, 0x81, 0x01)
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	opts := ParserOptions{
+		ReaderOptions: ebmlstream.ReaderOptions{MaxDepth: 1},
+		Recover:       true,
+	}
+	p := e.NewParserOptions(bytes.NewBuffer(b), opts)
+
+	for i := 0; i < 2; i++ {
+		_, err := p.Next()
+		require.Nil(t, err)
+	}
+	_, err = p.Next()
+	assert.True(t, errors.Is(err, ebmlstream.LimitExceeded), "err: %v", err)
+}
+
+func TestParseContext(t *T) {
+	var b []byte
+	b = append(b, 0x18, 0x53, 0x80, 0x67, 0xff)
+	b = append(b, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	b = append(b, 0xa3, 0x30, 0x00, 0x00)
+	b = append(b, make([]byte, 0x100000)...)
+
+	e, err := NewEdtd(bytes.NewBufferString(testParseEdtd))
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := e.NewParserContext(ctx, bytes.NewBuffer(b), ParserOptions{})
+
+	var el *Elem
+	for i := 0; i < 3; i++ {
+		el, err = p.Next()
+		require.Nil(t, err)
+	}
+	r, err := el.Reader()
+	require.Nil(t, err)
+	_, err = io.ReadFull(r, make([]byte, 1024))
+	require.Nil(t, err)
+
+	// Cancelling in the middle of the SimpleBlock stops it from being read, or
+	// skipped
+	cancel()
+	_, err = io.ReadFull(r, make([]byte, 1024))
+	assert.Equal(t, context.Canceled, err)
+	_, err = p.Next()
+	assert.Equal(t, context.Canceled, err)
+}
@@ -19,7 +19,7 @@ type rangeParam struct {
 func parseRangeParams(typ Type, r []*token) (*rangeParam, error) {
 	var f func(Type, *token) (*rangeParam, error)
 	switch typ {
-	case Int, String, Binary:
+	case Int, String, UTF8, Binary:
 		f = parseIntRange
 	case Uint:
 		f = parseUintRange
@@ -22,182 +22,545 @@
 package ebmlstream
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mediocregopher/ebmlstream/varint"
 )
 
+// SizeError is returned from a data method when the Elem's size isn't valid for
+// the type of data being read from it
+type SizeError struct {
+	Type string
+	Size uint64
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("invalid size %d for %s element", e.Size, e.Type)
+}
+
+var (
+	SizeUnknown     = errors.New("element size is unknown")
+	DataStreamed    = errors.New("element data is being streamed")
+	DataUnavailable = errors.New("element data is no longer in the stream")
+	NotASCII        = errors.New("string contains non-printable-ascii characters")
+	InvalidUTF8     = errors.New("string is not valid utf-8")
+)
+
 // Represents a single EBML element. EBML elements have only three properties:
 // a numeric id, a size (in bytes) and their actual data. The id and size can be
 // retrieved as fields on this struct, and data can be retrieved using one of
 // the methods (depending on the data type).
 //
 // When an Elem is retrieved (using Next()) and it is not a container element it
-// MUST have one of the data methods called (e.g. Int(), Bytes(), etc...) before
-// Next() is called again, as this is what causes the data to be actually read
-// from the reader. Data methods can be called multiple times, and different
-// ones can be called, but at least one MUST be called before Next(). If the
-// element is a container element then ONLY Next() can be called on it (although
-// it will still have Id and Size filled in).
+// MUST have one of the data methods called (e.g. Int(), Bytes(), etc...), or
+// Skip(), before Next() is called again, as this is what causes the data to be
+// actually read from (or skipped over in) the reader. Data methods can be
+// called multiple times, and different ones can be called, but at least one
+// MUST be called before Next(). If the element is a container element then ONLY
+// Next() (or Skip(), to skip its children) can be called on it (although it will
+// still have Id and Size filled in).
+//
+// Live streams often write container elements (e.g. Segment and Cluster in
+// matroska) with the reserved "unknown" size, in which case UnknownSize will be
+// true. Such a container only ends when an element which can't be its child is
+// encountered, which can only be determined using a schema (see the edtd
+// package). Data methods on a non-container element of unknown size will return
+// SizeUnknown.
+//
+// As an alternative to the data methods, Reader() can be used to stream an
+// element's data instead of reading it all into memory, or Skip() can be used to
+// not read it at all. Once Next() has moved the stream past an element its data
+// can no longer be read, and the data methods will return DataUnavailable
+// (unless they had already been called before).
 type Elem struct {
-	r   io.Reader
-	buf *bufio.Reader
+	s    *stream
 	data []byte
-
-	Id   varint.VarInt
-	Size varint.VarInt
+	lr   *io.LimitedReader
+	br   *bytes.Reader
+
+	Id          varint.VarInt
+	Size        varint.VarInt
+	UnknownSize bool
+
+	// Offset is the absolute position in the stream (in bytes, starting at 0)
+	// at which the Elem's id begins. HeaderSize is the combined length of the
+	// encoded id and size, and DataOffset is the position at which the Elem's
+	// data (or its first child, for a container) begins, i.e. Offset plus
+	// HeaderSize
+	Offset     int64
+	HeaderSize int
+	DataOffset int64
+
+	// The container the Elem is inside of, or nil if it's at the top level,
+	// and the number of containers it's inside of. An Elem is taken to be a
+	// container if Next() is called while the stream is still at the start of
+	// its data, i.e. none of the data methods, Reader() or Skip() were used on
+	// it.
+	Parent *Elem
+	Depth  int
+
+	// The containers which ended directly before this Elem, innermost first.
+	// A container of known size ends once the stream reaches the end of its
+	// data. One of unknown size only ends along with a container of known size
+	// it's inside of, unless EndFunc is used
+	Ended []*Elem
 }
 
 // Returns an Elem which represents the start of an unread EBML stream. Next()
 // is the only valid method which can be called on the Elem returned from this
 // function (see the package example).
 func RootElem(r io.Reader) *Elem {
-	return &Elem{
-		r:   r,
-		buf: bufio.NewReader(r),
-	}
+	return RootElemOptions(r, ReaderOptions{})
+}
+
+// ReaderOptions limit the resources which can be used while reading a stream,
+// to protect against corrupt or malicious input. The zero value of each field
+// means no limit. When a limit is reached a *ParseError wrapping LimitExceeded
+// is returned.
+type ReaderOptions struct {
+	// The largest an Elem's data can be for it to be read into memory by a
+	// data method. Reader() and Skip() aren't affected
+	MaxElementSize uint64
+
+	// The largest Depth an Elem can have
+	MaxDepth int
+
+	// The most bytes which can be consumed from the stream in total
+	MaxTotalBytes int64
+
+	// The most Elems which can be read from the stream in total
+	MaxElementCount int
+}
+
+// Like RootElem, but reading from the stream will be limited by the given
+// ReaderOptions
+func RootElemOptions(r io.Reader, opts ReaderOptions) *Elem {
+	s := newStream(r)
+	s.opts = opts
+	return &Elem{s: s}
 }
 
-// Returns the next Elem in the stream. When called on a non-container Elem this
-// MUST be called after a data method (e.g. Int(), Bytes(), etc...) has been
-// called at least once. For container Elems (and the root Elem) this is the
-// only valid method which can be called
+// Returns the next Elem in the stream. io.EOF is returned if the stream ends
+// cleanly, i.e. where the next Elem would have started and not before the end
+// of a container of known size, otherwise any problem with the stream is
+// returned as a *ParseError.
+//
+// When called on a non-container Elem this MUST be called after a data method
+// (e.g. Int(), Bytes(), etc...), Reader() or Skip() has been called at least
+// once. If Reader() was used any data left unread on it is discarded. For
+// container Elems (and the root Elem) this is the only valid method which can
+// be called
 func (e *Elem) Next() (*Elem, error) {
-	id, err := varint.Read(e.buf)
-	if err != nil {
+	if e.lr != nil {
+		if err := e.Skip(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.s.ctxErr(); err != nil {
 		return nil, err
 	}
 
-	size, err := varint.Read(e.buf)
+	if el := e.s.pending; el != nil {
+		e.s.pending = nil
+		return el, nil
+	}
+
+	if l := e.s.last; l != nil && l.entered() {
+		e.s.open = append(e.s.open, l)
+		e.s.startCRC(l)
+	}
+	e.s.last = nil
+
+	offset := e.s.pos
+	id, err := varint.Read(e.s)
+	if err == io.EOF {
+		return nil, e.s.end(offset)
+	} else if err != nil {
+		return nil, parseErr(offset, 0, err)
+	}
+
+	size, err := varint.Read(e.s)
 	if err != nil {
+		return nil, parseErr(offset, id, err)
+	}
+
+	el := &Elem{
+		s:           e.s,
+		Id:          id,
+		Size:        size,
+		UnknownSize: size.IsUnknown(),
+		Offset:      offset,
+		HeaderSize:  int(e.s.pos - offset),
+		DataOffset:  e.s.pos,
+	}
+	e.s.track(el)
+	e.s.hashHeader(el)
+
+	e.s.count++
+	if max := e.s.opts.MaxElementCount; max > 0 && e.s.count > max {
+		return nil, parseErr(offset, id, fmt.Errorf(
+			"%w: more than MaxElementCount (%d) elements",
+			LimitExceeded, max,
+		))
+	} else if max := e.s.opts.MaxDepth; max > 0 && el.Depth > max {
+		return nil, parseErr(offset, id, fmt.Errorf(
+			"%w: depth %d is greater than MaxDepth (%d)",
+			LimitExceeded, el.Depth, max,
+		))
+	}
+
+	// An error about a CRC32 container which just ended is returned first,
+	// with the Elem following it put back for the next call
+	if err := e.s.endCRCs(el.Offset); err != nil {
+		e.s.pending = el
 		return nil, err
 	}
+	return el, nil
+}
 
-	return &Elem{
-		r:    e.r,
-		buf:  e.buf,
-		Id:   id,
-		Size: size,
-	}, nil
+// Returns true if the stream is still at the start of the Elem's data, which
+// has some length, meaning it's being treated as a container
+func (e *Elem) entered() bool {
+	if e.data != nil || e.lr != nil || e.s.pos != e.DataOffset {
+		return false
+	} else if e.UnknownSize {
+		return true
+	}
+	size, err := e.Size.Uint64()
+	return err == nil && size > 0
+}
+
+// Returns the position in the stream the Elem ends at, i.e. where its next
+// sibling starts. Returns false if the Elem's size is unknown, in which case
+// there's no way of knowing where it ends from the Elem alone (see Ended).
+func (e *Elem) End() (int64, bool) {
+	if e.UnknownSize {
+		return 0, false
+	}
+	size, err := e.Size.Uint64()
+	if err != nil {
+		return 0, false
+	}
+	return e.DataOffset + int64(size), true
+}
+
+// Sets the function used to decide when containers end, other than by reaching
+// the end of their data, for all Elems read from the same stream. Each time
+// Next() reads an Elem, once any containers of known size it comes after have
+// been ended, the function is called with the innermost open container and the
+// new Elem. If it returns true the container is ended before the new Elem (see
+// Ended), and the function is called again with the next container out. This
+// is mainly for containers of unknown size, which without it only end along
+// with a container of known size they're inside of, but containers of known
+// size can be ended early as well.
+func (e *Elem) EndFunc(f func(container, next *Elem) bool) {
+	e.s.endFunc = f
+}
+
+// Sets a context for all Elems read from the same stream. Once the context is
+// done Next(), the data methods, and reads from Reader() all return its error
+// (ctx.Err()) rather than reading any further. The context is checked between
+// each read from the underlying io.Reader, so large elements can be stopped
+// partway through, but a read which is blocked can't be interrupted.
+func (e *Elem) SetContext(ctx context.Context) {
+	e.s.ctx = ctx
+}
+
+// Skips over the Elem's data without reading it into memory, as an alternative
+// to calling a data method. If the underlying io.Reader is an io.Seeker it will
+// be used to seek past the data. If some of the data has already been read (via
+// Reader()) only the remainder is skipped, and if it's all been read this does
+// nothing.
+//
+// When called on a container Elem all of its children are skipped as well, and
+// the next call to Next() will return the Elem following the container. This
+// cannot be done for a container of unknown size, in which case SizeUnknown is
+// returned.
+func (e *Elem) Skip() error {
+	if e.UnknownSize {
+		return SizeUnknown
+	}
+	size, err := e.Size.Uint64()
+	if err != nil {
+		return err
+	}
+	if rem := e.DataOffset + int64(size) - e.s.pos; rem > 0 {
+		if err := e.s.skip(rem); err != nil {
+			return parseErr(e.Offset, e.Id, err)
+		}
+	}
+	return nil
+}
+
+// Discards bytes from the stream, one at a time, until the upcoming bytes form
+// a valid element header whose id is accepted by the given function. The
+// offset of that element is returned, and the next call to Next() (on this or
+// any other Elem from the same stream) will return it. If the stream ends
+// before such an element is found io.EOF is returned.
+//
+// This is intended for recovering from corrupt data, by skipping ahead to an
+// element which is known to be a good place to start from again.
+func (e *Elem) Resync(accept func(varint.VarInt) bool) (int64, error) {
+	for {
+		if id, ok := e.s.peekHeader(); ok && accept(id) {
+			return e.s.pos, nil
+		}
+		if err := e.s.skip(1); err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, err
+		}
+	}
 }
 
 func (e *Elem) fillBuffer() error {
+	if e.UnknownSize {
+		return SizeUnknown
+	}
 	if e.data == nil {
+		if e.lr != nil {
+			return DataStreamed
+		} else if e.s.pos != e.DataOffset {
+			return DataUnavailable
+		}
 		size, err := e.Size.Uint64()
 		if err != nil {
 			return err
+		} else if max := e.s.opts.MaxElementSize; max > 0 && size > max {
+			return parseErr(e.Offset, e.Id, fmt.Errorf(
+				"%w: size %d is greater than MaxElementSize (%d)",
+				LimitExceeded, size, max,
+			))
 		}
-		e.data = make([]byte, size)
-		if _, err = io.ReadFull(e.buf, e.data); err != nil {
-			return err
+
+		// Large data is read in incrementally, so that a corrupt size can't
+		// cause a huge allocation when the stream doesn't have that much data
+		var data []byte
+		if size <= maxPrealloc {
+			data = make([]byte, size)
+			_, err = io.ReadFull(e.s, data)
+		} else {
+			buf := bytes.NewBuffer(make([]byte, 0, maxPrealloc))
+			_, err = io.CopyN(buf, e.s, int64(size))
+			data = buf.Bytes()
+		}
+		if err != nil {
+			return parseErr(e.Offset, e.Id, err)
 		}
+		e.data = data
 	}
 	return nil
 }
 
-// Returns a copy of b padded with zeros on the left so that it matches the
-// target size
-func leftPad(b []byte, targetSize int) []byte {
-	nb := make([]byte, targetSize)
-	copy(nb[targetSize-len(b):], b)
-	return nb
+// The largest amount of memory which is allocated for an Elem's data before
+// any of it has been read
+const maxPrealloc = 1 << 20
+
+// Returns a SizeError if the Elem's size is larger than max, which is the
+// largest size allowed for the given type of data
+func (e *Elem) checkSize(typ string, max uint64) error {
+	if e.UnknownSize {
+		return SizeUnknown
+	}
+	size, err := e.Size.Uint64()
+	if err != nil {
+		return err
+	} else if size > max {
+		return &SizeError{Type: typ, Size: size}
+	}
+	return nil
 }
 
 // Reads and returns the Elem's data as a signed integer. This can be called
-// multiple times.
+// multiple times. The integer may be anywhere from 0 to 8 bytes, and is sign
+// extended from however many bytes it actually is.
 func (e *Elem) Int() (int64, error) {
-	if e.Size == 0 {
-		return 0, nil
+	if err := e.checkSize("int", 8); err != nil {
+		return 0, err
 	} else if err := e.fillBuffer(); err != nil {
 		return 0, err
 	}
+	return decodeInt(e.data), nil
+}
 
-	var ret int64
-	buf := bytes.NewBuffer(leftPad(e.data, 8))
-	if err := binary.Read(buf, binary.BigEndian, &ret); err != nil {
-		return 0, err
+func decodeInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
 	}
-
-	return ret, nil
+	shift := uint(64 - 8*len(b))
+	return int64(decodeUint(b)<<shift) >> shift
 }
 
 // Reads and returns the Elem's data as an unsigned integer. This can be called
-// multiple times.
+// multiple times. The integer may be anywhere from 0 to 8 bytes.
 func (e *Elem) Uint() (uint64, error) {
-	if e.Size == 0 {
-		return 0, nil
-	}
-	if err := e.fillBuffer(); err != nil {
+	if err := e.checkSize("uint", 8); err != nil {
+		return 0, err
+	} else if err := e.fillBuffer(); err != nil {
 		return 0, err
 	}
+	return decodeUint(e.data), nil
+}
 
+func decodeUint(b []byte) uint64 {
 	var ret uint64
-	buf := bytes.NewBuffer(leftPad(e.data, 8))
-	if err := binary.Read(buf, binary.BigEndian, &ret); err != nil {
-		return 0, err
+	for i := range b {
+		ret = (ret << 8) | uint64(b[i])
 	}
-
-	return ret, nil
+	return ret
 }
 
-var timeStart = time.Date(
+// The point in time which ebml dates are relative to
+var DateEpoch = time.Date(
 	2001, time.January, 1,
 	0, 0, 0, 0,
 	time.UTC,
 )
 
 // Reads and returns the Elem's data as a Time. This can be called multiple
-// times.
+// times. Dates must be either 0 bytes (meaning DateEpoch) or 8, any other size
+// returns a SizeError.
 func (e *Elem) Date() (time.Time, error) {
-	i, err := e.Int()
+	i, err := e.DateNanos()
 	if err != nil {
 		return time.Time{}, err
 	}
-	return timeStart.Add(time.Duration(i)), nil
+	return DateEpoch.Add(time.Duration(i)), nil
+}
+
+// Reads and returns the Elem's data as a date, in its raw form of the number of
+// nanoseconds before or after DateEpoch. This can be called multiple times.
+func (e *Elem) DateNanos() (int64, error) {
+	if err := e.checkSize("date", 8); err != nil {
+		return 0, err
+	} else if err := e.fillBuffer(); err != nil {
+		return 0, err
+	} else if len(e.data) != 0 && len(e.data) != 8 {
+		return 0, &SizeError{Type: "date", Size: uint64(len(e.data))}
+	}
+	return decodeInt(e.data), nil
 }
 
 // Reads and returns the Elem's data as a float. This can be called multiple
-// times.
+// times. The float may be 0 bytes (meaning 0), 4 or 8 bytes (IEEE 754 single
+// or double precision), or 10 bytes (x87 extended precision, which is converted
+// to the nearest float64, see ExtFloat()). Any other size returns a SizeError.
 func (e *Elem) Float() (float64, error) {
-	if e.Size == 0 {
-		return 0, nil
-	} else if e.Size == 4 {
-		f, err := e.f32()
-		return float64(f), err
+	if err := e.checkSize("float", 10); err != nil {
+		return 0, err
 	} else if err := e.fillBuffer(); err != nil {
 		return 0, err
 	}
 
-	var ret float64
-	buf := bytes.NewBuffer(leftPad(e.data, 8))
-	if err := binary.Read(buf, binary.BigEndian, &ret); err != nil {
-		return 0, err
+	switch len(e.data) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(e.data))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(e.data)), nil
+	case 10:
+		f, nan := decodeExtFloat(e.data)
+		if nan {
+			return math.NaN(), nil
+		}
+		f64, _ := f.Float64()
+		return f64, nil
+	default:
+		return 0, &SizeError{Type: "float", Size: uint64(len(e.data))}
 	}
+}
 
-	return ret, nil
+// Reads and returns the Elem's data, which must be a 10 byte extended precision
+// float, as a big.Float with no loss of precision. Extended precision floats
+// may be NaN, which big.Float cannot represent, so that will return an error.
+// This can be called multiple times.
+func (e *Elem) ExtFloat() (*big.Float, error) {
+	if err := e.checkSize("extended float", 10); err != nil {
+		return nil, err
+	} else if err := e.fillBuffer(); err != nil {
+		return nil, err
+	} else if len(e.data) != 10 {
+		return nil, &SizeError{Type: "extended float", Size: uint64(len(e.data))}
+	}
+
+	f, nan := decodeExtFloat(e.data)
+	if nan {
+		return nil, errors.New("extended float is NaN")
+	}
+	return f, nil
 }
 
-func (e *Elem) f32() (float32, error) {
-	if err := e.fillBuffer(); err != nil {
-		return 0, err
+// Decodes a big-endian 80-bit x87 extended precision float: 1 sign bit, 15
+// exponent bits, and a 64 bit mantissa whose top bit is the explicit integer
+// bit. Returns true if the float is NaN
+func decodeExtFloat(b []byte) (*big.Float, bool) {
+	signExp := binary.BigEndian.Uint16(b[:2])
+	mant := binary.BigEndian.Uint64(b[2:])
+	neg := signExp&0x8000 != 0
+	exp := int(signExp & 0x7fff)
+
+	if exp == 0x7fff {
+		if mant<<1 != 0 {
+			return nil, true
+		}
+		return new(big.Float).SetInf(neg), false
+	} else if exp == 0 {
+		// denormals have the same exponent as the smallest normal number
+		exp = 1
 	}
 
-	var ret float32
-	buf := bytes.NewBuffer(leftPad(e.data, 8))
-	if err := binary.Read(buf, binary.BigEndian, &ret); err != nil {
-		return 0, err
+	f := new(big.Float).SetPrec(64).SetUint64(mant)
+	f.SetMantExp(f, exp-16383-63)
+	if neg {
+		f.Neg(f)
+	}
+	return f, false
+}
+
+// Reads and returns the Elem's data as a string. Ebml strings may only contain
+// printable ascii characters, if any others are found NotASCII is returned.
+// Strings may be padded with trailing zero bytes, which are not included in the
+// returned string. This can be called multiple times.
+func (e *Elem) Str() (string, error) {
+	ret, err := e.nulTerminated()
+	if err != nil {
+		return "", err
 	}
 
+	for i := 0; i < len(ret); i++ {
+		if ret[i] < 0x20 || ret[i] > 0x7e {
+			return "", NotASCII
+		}
+	}
 	return ret, nil
 }
 
-// Reads and returns the Elem's data as a string. This can be called multiple
-// times.
-func (e *Elem) Str() (string, error) {
+// Reads and returns the Elem's data as a utf-8 string. If the data isn't valid
+// utf-8 InvalidUTF8 is returned. Like Str(), trailing zero bytes are not
+// included in the returned string. This can be called multiple times.
+func (e *Elem) UTF8() (string, error) {
+	ret, err := e.nulTerminated()
+	if err != nil {
+		return "", err
+	} else if !utf8.ValidString(ret) {
+		return "", InvalidUTF8
+	}
+	return ret, nil
+}
+
+// Returns the Elem's data as a string, up until the first zero byte
+func (e *Elem) nulTerminated() (string, error) {
 	if e.Size == 0 {
 		return "", nil
 	} else if err := e.fillBuffer(); err != nil {
@@ -225,6 +588,35 @@ func (e *Elem) Bytes() ([]byte, error) {
 	return e.data, nil
 }
 
+// Returns an io.Reader which will read the Elem's data directly off of the
+// underlying stream, rather than reading it all into memory like the data
+// methods do. The io.Reader will return io.EOF once the end of the Elem's data
+// is reached. If a data method has already been called the io.Reader will read
+// from the data already in memory, otherwise data methods cannot be called
+// after this is (they will return DataStreamed). Calling this multiple times
+// returns the same io.Reader.
+func (e *Elem) Reader() (io.Reader, error) {
+	if e.UnknownSize {
+		return nil, SizeUnknown
+	} else if e.data != nil {
+		if e.br == nil {
+			e.br = bytes.NewReader(e.data)
+		}
+		return e.br, nil
+	} else if e.lr != nil {
+		return e.lr, nil
+	} else if e.s.pos != e.DataOffset {
+		return nil, DataUnavailable
+	}
+
+	size, err := e.Size.Uint64()
+	if err != nil {
+		return nil, err
+	}
+	e.lr = &io.LimitedReader{R: e.s, N: int64(size)}
+	return e.lr, nil
+}
+
 // Writes the Elem to the io.Writer as an ebml element. This will only write the
 // data portion of the Elem if one of the data methods has been called
 // previously. If the Elem is a container it's children will NOT be
@@ -2,8 +2,18 @@ package ebmlstream
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math"
+	"math/big"
+	"os"
 	. "testing"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
 )
 
 func sb(bs ...byte) string {
@@ -64,3 +74,397 @@ func TestStringElem(t *T) {
 		assert.Exactly(in, wbuf.String(), "input: %x", in)
 	}
 }
+
+func TestUnknownSizeElem(t *T) {
+	in := sb(0x80, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x81, 0x80)
+	assert := assert.New(t)
+
+	e, err := RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	assert.True(e.UnknownSize)
+	_, ok := e.End()
+	assert.False(ok)
+
+	_, err = e.Bytes()
+	assert.Equal(SizeUnknown, err)
+
+	// The element following an unknown-size container is its first child
+	e, err = e.Next()
+	assert.Nil(err)
+	assert.False(e.UnknownSize)
+	assert.Exactly(varint.VarInt(0x81), e.Id)
+}
+
+func TestReaderElem(t *T) {
+	in := sb(0x81, 0x85, 'h', 'e', 'l', 'l', 'o', 0x82, 0x81, 0x01)
+	assert := assert.New(t)
+
+	e, err := RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+
+	r, err := e.Reader()
+	assert.Nil(err)
+	b := make([]byte, 2)
+	_, err = io.ReadFull(r, b)
+	assert.Nil(err)
+	assert.Equal("he", string(b))
+
+	_, err = e.Bytes()
+	assert.Equal(DataStreamed, err)
+
+	// Next should discard the rest of the data which wasn't read
+	e, err = e.Next()
+	assert.Nil(err)
+	assert.Exactly(varint.VarInt(0x82), e.Id)
+	i, err := e.Uint()
+	assert.Nil(err)
+	assert.Exactly(uint64(1), i)
+
+	// Reading from the data already in memory, the same io.Reader is still
+	// returned each time
+	r, err = e.Reader()
+	assert.Nil(err)
+	_, err = io.ReadFull(r, b[:1])
+	assert.Nil(err)
+	r, err = e.Reader()
+	assert.Nil(err)
+	_, err = r.Read(b)
+	assert.Equal(io.EOF, err)
+}
+
+func TestSkipElem(t *T) {
+	in := sb(
+		0x81, 0x83, 0x01, 0x02, 0x03,
+		0x82, 0x84, 0x83, 0x81, 0x01, 0x84,
+		0x85, 0x81, 0x05,
+	)
+	assert := assert.New(t)
+
+	// bytes.Reader implements io.Seeker and bytes.Buffer doesn't, so both ways
+	// of skipping get tested
+	rs := []io.Reader{bytes.NewBufferString(in), bytes.NewReader([]byte(in))}
+	for _, r := range rs {
+		e, err := RootElem(r).Next()
+		assert.Nil(err)
+		assert.Nil(e.Skip())
+
+		_, err = e.Bytes()
+		assert.Equal(DataUnavailable, err)
+
+		// Skipping a container skips all of its children
+		e, err = e.Next()
+		assert.Nil(err)
+		assert.Exactly(varint.VarInt(0x82), e.Id)
+		assert.Nil(e.Skip())
+
+		e, err = e.Next()
+		assert.Nil(err)
+		assert.Exactly(varint.VarInt(0x85), e.Id)
+		i, err := e.Uint()
+		assert.Nil(err)
+		assert.Exactly(uint64(5), i)
+	}
+}
+
+func TestSkipElemTruncated(t *T) {
+	in := []byte{0x81, 0x88, 0x01, 0x02, 0x03}
+	rs := []io.Reader{bytes.NewBuffer(in), bytes.NewReader(in)}
+	for _, r := range rs {
+		e, err := RootElem(r).Next()
+		require.Nil(t, err)
+		assert.True(t, errors.Is(e.Skip(), Truncated), "%T", r)
+	}
+}
+
+func TestSkipElemPipe(t *T) {
+	// An *os.File of a pipe is an io.Seeker, but can't actually seek
+	pr, pw, err := os.Pipe()
+	require.Nil(t, err)
+	defer pr.Close()
+	go func() {
+		pw.Write([]byte{0x81, 0x10, 0x00, 0x80, 0x00})
+		pw.Write(make([]byte, 0x8000))
+		pw.Write([]byte{0x82, 0x81, 0x05})
+		pw.Close()
+	}()
+
+	e, err := RootElem(pr).Next()
+	require.Nil(t, err)
+	require.Nil(t, e.Skip())
+	e, err = e.Next()
+	require.Nil(t, err)
+	assert.Exactly(t, varint.VarInt(0x82), e.Id)
+	i, err := e.Uint()
+	require.Nil(t, err)
+	assert.Exactly(t, uint64(5), i)
+}
+
+func TestElemOffsets(t *T) {
+	in := sb(0x1a, 0x45, 0xdf, 0xa3, 0x84, 0x42, 0x86, 0x81, 0x01, 0xec, 0x40, 0x01, 0x00)
+	assert := assert.New(t)
+
+	expect := [][4]int64{{0, 5, 5, 9}, {5, 3, 8, 9}, {9, 3, 12, 13}}
+	e := RootElem(bytes.NewBufferString(in))
+	for i := range expect {
+		var err error
+		e, err = e.Next()
+		assert.Nil(err)
+		assert.Equal(expect[i][0], e.Offset, "elem: %d", i)
+		assert.Equal(int(expect[i][1]), e.HeaderSize, "elem: %d", i)
+		assert.Equal(expect[i][2], e.DataOffset, "elem: %d", i)
+		end, ok := e.End()
+		assert.True(ok, "elem: %d", i)
+		assert.Equal(expect[i][3], end, "elem: %d", i)
+		if i > 0 {
+			assert.Nil(e.Skip())
+		}
+	}
+}
+
+func TestNegativeIntElem(t *T) {
+	m := map[string]int64{
+		sb(0x80, 0x81, 0xff):             -1,
+		sb(0x80, 0x81, 0x80):             -128,
+		sb(0x80, 0x82, 0xff, 0x7f):       -129,
+		sb(0x80, 0x83, 0xfe, 0xdc, 0xba): -0x012346,
+	}
+	assert := assert.New(t)
+	for in, out := range m {
+		e, err := RootElem(bytes.NewBufferString(in)).Next()
+		assert.Nil(err, "input: %x", in)
+
+		i, err := e.Int()
+		assert.Nil(err, "input: %x", in)
+		assert.Exactly(out, i, "input: %x", in)
+		assert.Equal([]byte(in[2:]), EncodeInt(i), "input: %x", in)
+	}
+}
+
+func TestIntElemSize(t *T) {
+	in := sb(0x80, 0x89, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	assert := assert.New(t)
+
+	e, err := RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	_, err = e.Int()
+	assert.Equal(&SizeError{Type: "int", Size: 9}, err)
+	_, err = e.Uint()
+	assert.Equal(&SizeError{Type: "uint", Size: 9}, err)
+}
+
+func TestFloatElem(t *T) {
+	m := map[string]float64{
+		sb(0x80, 0x80):                                                 0,
+		sb(0x80, 0x84, 0x3f, 0xc0, 0x00, 0x00):                         1.5,
+		sb(0x80, 0x84, 0xc1, 0x20, 0x00, 0x00):                         -10,
+		sb(0x80, 0x88, 0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18): math.Pi,
+		sb(0x80, 0x8a, 0x3f, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0):          1,
+		sb(0x80, 0x8a, 0xc0, 0x00, 0xa0, 0, 0, 0, 0, 0, 0, 0):          -2.5,
+	}
+	assert := assert.New(t)
+	for in, out := range m {
+		e, err := RootElem(bytes.NewBufferString(in)).Next()
+		assert.Nil(err, "input: %x", in)
+
+		f, err := e.Float()
+		assert.Nil(err, "input: %x", in)
+		assert.Exactly(out, f, "input: %x", in)
+	}
+
+	e, err := RootElem(bytes.NewBufferString(sb(0x80, 0x83, 1, 2, 3))).Next()
+	assert.Nil(err)
+	_, err = e.Float()
+	assert.Equal(&SizeError{Type: "float", Size: 3}, err)
+
+	// 1 + 2^-63 can't be represented by a float64, but can by ExtFloat
+	in := sb(0x80, 0x8a, 0x3f, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 1)
+	e, err = RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	bf, err := e.ExtFloat()
+	assert.Nil(err)
+	expect := new(big.Float).SetPrec(64).SetInt64(1)
+	expect.Add(expect, new(big.Float).SetMantExp(big.NewFloat(1), -63))
+	assert.Equal(0, expect.Cmp(bf))
+}
+
+func TestUTF8Elem(t *T) {
+	assert := assert.New(t)
+
+	in := sb(0x80, 0x85, 0xc3, 0xa9, 't', 0xc3, 0xa9)
+	e, err := RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	s, err := e.UTF8()
+	assert.Nil(err)
+	assert.Equal("été", s)
+	_, err = e.Str()
+	assert.Equal(NotASCII, err)
+
+	in = sb(0x80, 0x83, 'a', 0xff, 0)
+	e, err = RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	_, err = e.UTF8()
+	assert.Equal(InvalidUTF8, err)
+}
+
+func TestDateElem(t *T) {
+	assert := assert.New(t)
+	date := DateEpoch.Add(-1500 * time.Millisecond)
+
+	b, err := EncodeDate(date)
+	assert.Nil(err)
+	in := sb(0x80, 0x88) + string(b)
+	e, err := RootElem(bytes.NewBufferString(in)).Next()
+	assert.Nil(err)
+	d, err := e.Date()
+	assert.Nil(err)
+	assert.True(date.Equal(d))
+	ns, err := e.DateNanos()
+	assert.Nil(err)
+	assert.Equal(int64(-1500e6), ns)
+
+	e, err = RootElem(bytes.NewBufferString(sb(0x80, 0x84, 1, 2, 3, 4))).Next()
+	assert.Nil(err)
+	_, err = e.Date()
+	assert.Equal(&SizeError{Type: "date", Size: 4}, err)
+
+	_, err = EncodeDate(DateEpoch.AddDate(500, 0, 0))
+	assert.Equal(DateOutOfRange, err)
+}
+
+func TestElemDepth(t *T) {
+	in := sb(
+		0x81, 0x87,
+		0x82, 0x83,
+		0x83, 0x81, 0x01,
+		0x84, 0x80,
+		0x85, 0x80,
+	)
+	e := RootElem(bytes.NewBufferString(in))
+	expect := []struct {
+		id     varint.VarInt
+		depth  int
+		parent varint.VarInt
+		ended  []varint.VarInt
+	}{
+		{0x81, 0, 0, nil},
+		{0x82, 1, 0x81, nil},
+		{0x83, 2, 0x82, nil},
+		{0x84, 1, 0x81, []varint.VarInt{0x82}},
+		{0x85, 0, 0, []varint.VarInt{0x81}},
+	}
+
+	var err error
+	for i, x := range expect {
+		e, err = e.Next()
+		require.Nil(t, err, "elem: %d", i)
+		assert.Equal(t, x.id, e.Id, "elem: %d", i)
+		assert.Equal(t, x.depth, e.Depth, "elem: %d", i)
+		if x.parent == 0 {
+			assert.Nil(t, e.Parent, "elem: %d", i)
+		} else {
+			require.NotNil(t, e.Parent, "elem: %d", i)
+			assert.Equal(t, x.parent, e.Parent.Id, "elem: %d", i)
+		}
+		var ended []varint.VarInt
+		for _, c := range e.Ended {
+			ended = append(ended, c.Id)
+		}
+		assert.Equal(t, x.ended, ended, "elem: %d", i)
+
+		if x.id > 0x82 {
+			_, err := e.Bytes()
+			require.Nil(t, err)
+		}
+	}
+}
+
+func TestElemDepthUnknown(t *T) {
+	in := sb(
+		0x81, 0xff,
+		0x82, 0x81, 0x01,
+		0x81, 0xff,
+		0x82, 0x81, 0x02,
+	)
+
+	for _, endUnknown := range []bool{false, true} {
+		e := RootElem(bytes.NewBufferString(in))
+		if endUnknown {
+			e.EndFunc(func(c, next *Elem) bool { return next.Id == 0x81 })
+		}
+
+		var depths []int
+		var err error
+		for {
+			if e, err = e.Next(); err == io.EOF {
+				break
+			}
+			require.Nil(t, err)
+			depths = append(depths, e.Depth)
+			if e.Id == 0x82 {
+				_, err := e.Uint()
+				require.Nil(t, err)
+			}
+		}
+		if endUnknown {
+			assert.Equal(t, []int{0, 1, 0, 1}, depths)
+		} else {
+			assert.Equal(t, []int{0, 1, 1, 2}, depths)
+		}
+	}
+}
+
+func TestElemLimits(t *T) {
+	isLimit := func(err error) bool { return errors.Is(err, LimitExceeded) }
+
+	// A huge size with hardly any data shouldn't be allocated for
+	huge := sb(0x81, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, 0x01, 0x02, 0x03)
+	e, err := RootElem(bytes.NewBufferString(huge)).Next()
+	require.Nil(t, err)
+	_, err = e.Bytes()
+	assert.True(t, errors.Is(err, Truncated), "err: %v", err)
+
+	opts := ReaderOptions{MaxElementSize: 1024}
+	e, err = RootElemOptions(bytes.NewBufferString(huge), opts).Next()
+	require.Nil(t, err)
+	_, err = e.Bytes()
+	assert.True(t, isLimit(err), "err: %v", err)
+
+	nested := sb(0x81, 0x84, 0x82, 0x82, 0x83, 0x80)
+	e = RootElemOptions(bytes.NewBufferString(nested), ReaderOptions{MaxDepth: 1})
+	for i := 0; i < 2; i++ {
+		e, err = e.Next()
+		require.Nil(t, err)
+	}
+	_, err = e.Next()
+	assert.True(t, isLimit(err), "err: %v", err)
+
+	flat := sb(0x82, 0x81, 0x01, 0x83, 0x81, 0x02)
+	readAll := func(opts ReaderOptions) error {
+		for e, err := range RootElemOptions(bytes.NewBufferString(flat), opts).All() {
+			if err != nil {
+				return err
+			} else if _, err := e.Uint(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	assert.Nil(t, readAll(ReaderOptions{MaxTotalBytes: 6, MaxElementCount: 2}))
+	assert.True(t, isLimit(readAll(ReaderOptions{MaxTotalBytes: 5})))
+	assert.True(t, isLimit(readAll(ReaderOptions{MaxTotalBytes: 3})))
+	assert.True(t, isLimit(readAll(ReaderOptions{MaxElementCount: 1})))
+}
+
+func TestElemContext(t *T) {
+	e := RootElem(bytes.NewBufferString(sb(0x82, 0x81, 0x01, 0x83, 0x81, 0x02)))
+	ctx, cancel := context.WithCancel(context.Background())
+	e.SetContext(ctx)
+
+	e, err := e.Next()
+	require.Nil(t, err)
+	cancel()
+	_, err = e.Uint()
+	assert.Equal(t, context.Canceled, err)
+	_, err = e.Next()
+	assert.Equal(t, context.Canceled, err)
+}
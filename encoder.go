@@ -0,0 +1,369 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+var (
+	NoOpenContainer = errors.New("no container is open")
+	DateOutOfRange  = errors.New("date can't be encoded")
+)
+
+// The width of the size field which is reserved for containers, so that any
+// size can be patched in afterwards, unless MaxSizeLength is used
+const reservedSizeWidth = 8
+
+// Encoder writes ebml elements to an io.Writer. Containers are started with
+// StartContainer and ended with EndContainer, with everything written in
+// between being the container's children.
+//
+// An Encoder created with NewEncoder writes to an io.WriteSeeker. Since a
+// container's size isn't known until it's ended the Encoder reserves space for
+// it and seeks back to fill it in during EndContainer.
+//
+// An Encoder created with NewStreamEncoder can't seek, and so writes containers
+// with the reserved "unknown" size instead (as is done by live streaming
+// encoders). For containers which should still have their exact size written
+// StartBufferedContainer can be used, which holds the container in memory until
+// it's ended.
+type Encoder struct {
+	w    io.Writer
+	ws   io.WriteSeeker
+	open []*openContainer
+
+	// ids of the containers which get CRC-32s, see CRC32
+	crc map[varint.VarInt]bool
+
+	// The longest size which can be written, see MaxSizeLength. 0 means the
+	// default of reservedSizeWidth
+	maxSizeLength int
+}
+
+type openContainer struct {
+	// The position in ws the container's data starts at, for containers whose
+	// size gets patched in. -1 otherwise
+	dataOffset int64
+
+	// For buffered containers, the id and the buffer which the container's
+	// data is being written to, and the io.Writer the container itself will
+	// be written to once ended
+	id      varint.VarInt
+	buf     *bytes.Buffer
+	parentW io.Writer
+
+	// true if this is a CRC32 container started by the Encoder itself
+	crc bool
+}
+
+// The ids of the CRC32 container and its CRC32Value
+const (
+	crc32ID      = varint.VarInt(0xc3)
+	crc32ValueID = varint.VarInt(0x42fe)
+)
+
+// Returns an Encoder which will write to the given io.WriteSeeker, starting at
+// its current position
+func NewEncoder(ws io.WriteSeeker) *Encoder {
+	return &Encoder{w: ws, ws: ws}
+}
+
+// Returns an Encoder which will write to the given io.Writer without ever
+// seeking. Containers will be written with unknown size unless they're started
+// with StartBufferedContainer (or are inside one which was)
+func NewStreamEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Limits all sizes written by the Encoder to n bytes (1 to 8), for documents
+// whose EBML header has an EBMLMaxSizeLength of less than 8. The sizes
+// reserved for containers, and the unknown size, are written with n bytes
+// instead of 8. Elements whose size is too large for n bytes can't be written,
+// varint.IntegerTooBig is returned for them. An error is returned if n isn't
+// between 1 and 8, in which case the Encoder is left unchanged.
+func (e *Encoder) MaxSizeLength(n int) error {
+	if n < 1 || n > 8 {
+		return fmt.Errorf("invalid MaxSizeLength %d, must be 1 to 8", n)
+	}
+	e.maxSizeLength = n
+	return nil
+}
+
+// Returns the width of the sizes reserved for containers
+func (e *Encoder) reservedWidth() int {
+	if e.maxSizeLength > 0 {
+		return e.maxSizeLength
+	}
+	return reservedSizeWidth
+}
+
+// Encodes the size in exactly as many bytes as are reserved for containers
+func (e *Encoder) encodeReservedSize(size uint64) ([]byte, error) {
+	return encodeSizeWidth(size, e.reservedWidth())
+}
+
+// Encodes the size as a varint of exactly width bytes. The all-ones value is
+// reserved to mean unknown, so it can't be used
+func encodeSizeWidth(size uint64, width int) ([]byte, error) {
+	v, err := varint.EncodeWidth(size, width)
+	if err != nil {
+		return nil, err
+	}
+	return v.Append(make([]byte, 0, width))
+}
+
+// Returns true if the Encoder is currently writing into a buffered container
+func (e *Encoder) buffering() bool {
+	return len(e.open) > 0 && e.open[len(e.open)-1].buf != nil
+}
+
+// Causes containers with any of the given ids to have CRC-32s written for
+// them. Each such container will have a CRC32 container written as its only
+// child, holding all of the children written to it and a CRC32Value of them
+// (the little-endian IEEE CRC-32). This is done transparently, the container is
+// still started and ended with a single StartContainer/EndContainer call. Since
+// the CRC-32 can't be calculated until the container is ended its contents are
+// held in memory.
+func (e *Encoder) CRC32(ids ...varint.VarInt) {
+	if e.crc == nil {
+		e.crc = map[varint.VarInt]bool{}
+	}
+	for _, id := range ids {
+		e.crc[id] = true
+	}
+}
+
+// Starts a new container element with the given id. All elements written after
+// this are children of the container, until EndContainer is called
+func (e *Encoder) StartContainer(id varint.VarInt) error {
+	if e.buffering() {
+		return e.StartBufferedContainer(id)
+	}
+
+	// The size is encoded first, so that nothing is written if it can't be
+	var size []byte
+	if e.ws == nil {
+		unknown, err := varint.Unknown(e.reservedWidth())
+		if err != nil {
+			return err
+		} else if size, err = unknown.Append(nil); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if size, err = e.encodeReservedSize(0); err != nil {
+			return err
+		}
+	}
+
+	if _, err := id.WriteTo(e.w); err != nil {
+		return err
+	} else if _, err := e.w.Write(size); err != nil {
+		return err
+	}
+
+	if e.ws == nil {
+		e.open = append(e.open, &openContainer{dataOffset: -1})
+		e.startCRC32(id)
+		return nil
+	}
+
+	pos, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	e.open = append(e.open, &openContainer{dataOffset: pos})
+	e.startCRC32(id)
+	return nil
+}
+
+// Starts a new container element with the given id, like StartContainer, but
+// the container and everything in it is held in memory until EndContainer is
+// called, at which point it's written out with its exact size
+func (e *Encoder) StartBufferedContainer(id varint.VarInt) error {
+	e.startBuffered(id)
+	e.startCRC32(id)
+	return nil
+}
+
+func (e *Encoder) startBuffered(id varint.VarInt) *openContainer {
+	c := &openContainer{
+		dataOffset: -1,
+		id:         id,
+		buf:        new(bytes.Buffer),
+		parentW:    e.w,
+	}
+	e.open = append(e.open, c)
+	e.w = c.buf
+	return c
+}
+
+// If the container with the given id, which was just started, should have a
+// CRC-32 this starts the CRC32 container inside of it
+func (e *Encoder) startCRC32(id varint.VarInt) {
+	if e.crc[id] {
+		e.startBuffered(crc32ID).crc = true
+	}
+}
+
+// Ends the most recently started container, writing in its size if possible.
+// Returns NoOpenContainer if there is no container to end
+func (e *Encoder) EndContainer() error {
+	if len(e.open) == 0 {
+		return NoOpenContainer
+	}
+
+	if c := e.open[len(e.open)-1]; c.crc {
+		sum := make([]byte, 4)
+		binary.LittleEndian.PutUint32(sum, crc32.ChecksumIEEE(c.buf.Bytes()))
+		if err := e.put(crc32ValueID, sum); err != nil {
+			return err
+		} else if err := e.endContainer(); err != nil {
+			return err
+		}
+	}
+	return e.endContainer()
+}
+
+func (e *Encoder) endContainer() error {
+	c := e.open[len(e.open)-1]
+	e.open = e.open[:len(e.open)-1]
+
+	if c.buf != nil {
+		e.w = c.parentW
+		return e.put(c.id, c.buf.Bytes())
+	} else if c.dataOffset < 0 {
+		return nil
+	}
+
+	end, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	b, err := e.encodeReservedSize(uint64(end - c.dataOffset))
+	if err != nil {
+		return err
+	}
+	if _, err := e.ws.Seek(c.dataOffset-int64(len(b)), io.SeekStart); err != nil {
+		return err
+	} else if _, err := e.ws.Write(b); err != nil {
+		return err
+	}
+
+	_, err = e.ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// Writes a single element with the given id and data
+func (e *Encoder) put(id varint.VarInt, data []byte) error {
+	size, err := varint.Encode(uint64(len(data)))
+	if err != nil {
+		return err
+	} else if w, _ := size.Size(); w > e.reservedWidth() {
+		return varint.IntegerTooBig
+	}
+
+	var hb [maxHeaderSize]byte
+	header, err := id.Append(hb[:0])
+	if err != nil {
+		return err
+	} else if header, err = size.Append(header); err != nil {
+		return err
+	} else if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Returns the encoded form of an unsigned integer element's data, using as few
+// bytes as possible (0 is encoded as no bytes at all)
+func EncodeUint(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return bytes.TrimLeft(b, "\x00")
+}
+
+// Returns the encoded form of a signed integer element's data, using as few
+// bytes as possible while still having the correct sign when read back (0 is
+// encoded as no bytes at all)
+func EncodeInt(i int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+
+	// A leading byte can be dropped if it's only sign extension, i.e. it's all
+	// zeros or all ones and the following byte's top bit matches it
+	for len(b) > 0 {
+		if len(b) == 1 {
+			if b[0] == 0 {
+				b = b[1:]
+			}
+			break
+		} else if (b[0] == 0 && b[1]&0x80 == 0) ||
+			(b[0] == 0xff && b[1]&0x80 != 0) {
+			b = b[1:]
+		} else {
+			break
+		}
+	}
+	return b
+}
+
+// Returns the encoded form of a date element's data, which is always 8 bytes.
+// Dates are stored as a signed number of nanoseconds from DateEpoch, so only
+// dates within roughly 292 years of it can be encoded, DateOutOfRange is
+// returned for any others
+func EncodeDate(t time.Time) ([]byte, error) {
+	d := t.Sub(DateEpoch)
+	if !DateEpoch.Add(d).Equal(t) {
+		return nil, DateOutOfRange
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(d))
+	return b, nil
+}
+
+// Writes an unsigned integer element, using as few bytes as possible
+func (e *Encoder) PutUint(id varint.VarInt, i uint64) error {
+	return e.put(id, EncodeUint(i))
+}
+
+// Writes a signed integer element, using as few bytes as possible
+func (e *Encoder) PutInt(id varint.VarInt, i int64) error {
+	return e.put(id, EncodeInt(i))
+}
+
+// Writes a float element, as an 8 byte float
+func (e *Encoder) PutFloat(id varint.VarInt, f float64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return e.put(id, b)
+}
+
+// Writes a string element
+func (e *Encoder) PutString(id varint.VarInt, s string) error {
+	return e.put(id, []byte(s))
+}
+
+// Writes a date element
+func (e *Encoder) PutDate(id varint.VarInt, t time.Time) error {
+	b, err := EncodeDate(t)
+	if err != nil {
+		return err
+	}
+	return e.put(id, b)
+}
+
+// Writes a binary element
+func (e *Encoder) PutBinary(id varint.VarInt, b []byte) error {
+	return e.put(id, b)
+}
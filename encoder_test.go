@@ -0,0 +1,201 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io"
+	"math"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// An in-memory io.WriteSeeker
+type seekBuffer struct {
+	b   []byte
+	pos int64
+}
+
+func (sb *seekBuffer) Write(b []byte) (int, error) {
+	if end := sb.pos + int64(len(b)); end > int64(len(sb.b)) {
+		sb.b = append(sb.b, make([]byte, end-int64(len(sb.b)))...)
+	}
+	copy(sb.b[sb.pos:], b)
+	sb.pos += int64(len(b))
+	return len(b), nil
+}
+
+func (sb *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sb.pos
+	case io.SeekEnd:
+		offset += int64(len(sb.b))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	sb.pos = offset
+	return offset, nil
+}
+
+func TestEncoder(t *T) {
+	buf := &seekBuffer{}
+	enc := NewEncoder(buf)
+	date := DateEpoch.Add(5 * time.Second)
+
+	require.Nil(t, enc.StartContainer(0x81))
+	require.Nil(t, enc.PutUint(0x82, 0x0102))
+	require.Nil(t, enc.PutInt(0x83, 300))
+	require.Nil(t, enc.StartContainer(0x84))
+	require.Nil(t, enc.PutFloat(0x85, 1.5))
+	require.Nil(t, enc.PutString(0x86, "foo"))
+	require.Nil(t, enc.EndContainer())
+	require.Nil(t, enc.PutDate(0x87, date))
+	require.Nil(t, enc.PutBinary(0x88, []byte{1, 2, 3}))
+	require.Nil(t, enc.EndContainer())
+	assert.Equal(t, NoOpenContainer, enc.EndContainer())
+
+	e, err := RootElem(bytes.NewReader(buf.b)).Next()
+	require.Nil(t, err)
+	size, err := e.Size.Uint64()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(len(buf.b)-9), size)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	ui, err := e.Uint()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0x0102), ui)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	i, err := e.Int()
+	require.Nil(t, err)
+	assert.Equal(t, int64(300), i)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	size, err = e.Size.Uint64()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(15), size)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	f, err := e.Float()
+	require.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	s, err := e.Str()
+	require.Nil(t, err)
+	assert.Equal(t, "foo", s)
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	d, err := e.Date()
+	require.Nil(t, err)
+	assert.True(t, date.Equal(d))
+
+	e, err = e.Next()
+	require.Nil(t, err)
+	b, err := e.Bytes()
+	require.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, b)
+
+	_, err = e.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamEncoder(t *T) {
+	buf := new(bytes.Buffer)
+	enc := NewStreamEncoder(buf)
+
+	require.Nil(t, enc.StartContainer(0x81))
+	require.Nil(t, enc.StartBufferedContainer(0x82))
+	require.Nil(t, enc.PutUint(0x83, 1))
+	require.Nil(t, enc.StartContainer(0x84))
+	require.Nil(t, enc.EndContainer())
+	require.Nil(t, enc.EndContainer())
+	require.Nil(t, enc.StartContainer(0x85))
+	require.Nil(t, enc.EndContainer())
+	require.Nil(t, enc.EndContainer())
+
+	expect := []byte{
+		0x81, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x82, 0x85,
+		0x83, 0x81, 0x01,
+		0x84, 0x80,
+		0x85, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	assert.Equal(t, expect, buf.Bytes())
+
+	e, err := RootElem(buf).Next()
+	require.Nil(t, err)
+	assert.True(t, e.UnknownSize)
+}
+
+func TestEncoderCRC32(t *T) {
+	sb := new(seekBuffer)
+	enc := NewEncoder(sb)
+	enc.CRC32(0x81)
+
+	require.Nil(t, enc.StartContainer(0x81))
+	require.Nil(t, enc.PutUint(0x83, 1))
+	require.Nil(t, enc.PutString(0x84, "hi"))
+	require.Nil(t, enc.EndContainer())
+
+	sum := crc32.ChecksumIEEE([]byte{0x83, 0x81, 0x01, 0x84, 0x82, 'h', 'i'})
+	expect := []byte{
+		0x81, 0x01, 0, 0, 0, 0, 0, 0, 0x10,
+		0xc3, 0x8e,
+		0x83, 0x81, 0x01,
+		0x84, 0x82, 'h', 'i',
+		0x42, 0xfe, 0x84, byte(sum), byte(sum >> 8), byte(sum >> 16), byte(sum >> 24),
+	}
+	assert.Equal(t, expect, sb.b)
+}
+
+func TestEncoderMaxSizeLength(t *T) {
+	sb := new(seekBuffer)
+	enc := NewEncoder(sb)
+	require.Nil(t, enc.MaxSizeLength(4))
+	require.Nil(t, enc.StartContainer(0x81))
+	require.Nil(t, enc.PutUint(0x82, 1))
+	require.Nil(t, enc.EndContainer())
+	assert.Equal(t, []byte{0x81, 0x10, 0x00, 0x00, 0x03, 0x82, 0x81, 0x01}, sb.b)
+
+	buf := new(bytes.Buffer)
+	enc = NewStreamEncoder(buf)
+	require.Nil(t, enc.MaxSizeLength(1))
+	require.Nil(t, enc.StartContainer(0x81))
+	assert.Equal(t, varint.IntegerTooBig, enc.PutBinary(0x82, make([]byte, 128)))
+	require.Nil(t, enc.EndContainer())
+	assert.Equal(t, []byte{0x81, 0xff}, buf.Bytes())
+
+	assert.NotNil(t, enc.MaxSizeLength(0))
+	assert.NotNil(t, enc.MaxSizeLength(9))
+	assert.Equal(t, 1, enc.reservedWidth())
+}
+
+func TestEncodeInt(t *T) {
+	ints := []int64{
+		0, 1, -1, 127, 128, -128, -129, 0x7fff, -0x8000, 0x123456,
+		math.MaxInt64, math.MinInt64,
+	}
+	sizes := []int{0, 1, 1, 1, 2, 1, 2, 2, 2, 3, 8, 8}
+	for i := range ints {
+		b := EncodeInt(ints[i])
+		assert.Len(t, b, sizes[i], "input: %d", ints[i])
+		assert.Equal(t, ints[i], decodeInt(b), "input: %d", ints[i])
+	}
+
+	assert.Len(t, EncodeUint(0), 0)
+	assert.Equal(t, []byte{0xff}, EncodeUint(0xff))
+	assert.Equal(t, uint64(math.MaxUint64), decodeUint(EncodeUint(math.MaxUint64)))
+}
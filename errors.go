@@ -0,0 +1,75 @@
+package ebmlstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// These describe the different ways in which an ebml stream can be malformed.
+// A ParseError's Cause will always be, or wrap, one of these or
+// varint.InvalidVarInt, so they can be checked for using errors.Is
+var (
+	Truncated       = errors.New("stream ended in the middle of an element")
+	UnknownElement  = errors.New("unknown element")
+	SchemaViolation = errors.New("element violates schema")
+	CRCMismatch     = errors.New("crc-32 does not match data")
+	VarIntTooLong   = errors.New("element id or size is longer than the header allows")
+	LimitExceeded   = errors.New("limit exceeded")
+)
+
+// ParseError is returned when an ebml stream is found to be malformed. It
+// describes where in the stream the problem was found
+type ParseError struct {
+	// The absolute position in the stream of the element the problem was found
+	// with (or in the header of)
+	Offset int64
+
+	// The id of the element the problem was found with, if it was able to be
+	// read
+	ElementID varint.VarInt
+
+	// The names of the containers the element is in, outermost first. This is
+	// only filled in for errors returned from an edtd Parser's methods, since
+	// a schema is needed to know the names
+	Path []string
+
+	Cause error
+}
+
+func (e *ParseError) Error() string {
+	s := fmt.Sprintf("offset %d", e.Offset)
+	if e.ElementID != 0 {
+		s += fmt.Sprintf(", id %x", uint64(e.ElementID))
+	}
+	if len(e.Path) > 0 {
+		s += ", in " + strings.Join(e.Path, "/")
+	}
+	return fmt.Sprintf("ebml parse error (%s): %s", s, e.Cause)
+}
+
+// Allows for using errors.Is and errors.As on a ParseError's Cause
+func (e *ParseError) Unwrap() error {
+	return e.Cause
+}
+
+// Returns a ParseError for the error encountered while reading the element at
+// the given offset. An io.EOF or io.ErrUnexpectedEOF is considered Truncated,
+// since any EOF within an element is unexpected. Context errors aren't a
+// problem with the stream, so they're returned as they are
+func parseErr(offset int64, id varint.VarInt, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: %w", Truncated, io.ErrUnexpectedEOF)
+	}
+	return &ParseError{
+		Offset:    offset,
+		ElementID: id,
+		Cause:     err,
+	}
+}
@@ -0,0 +1,54 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	. "testing"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+func TestParseErrors(t *T) {
+	assert := assert.New(t)
+
+	// Truncated data
+	e, err := RootElem(bytes.NewBufferString(sb(0x81, 0x84, 1, 2))).Next()
+	assert.Nil(err)
+	_, err = e.Bytes()
+	var perr *ParseError
+	assert.True(errors.As(err, &perr))
+	assert.Exactly(varint.VarInt(0x81), perr.ElementID)
+	assert.True(errors.Is(err, Truncated))
+	assert.True(errors.Is(err, io.ErrUnexpectedEOF))
+
+	// Truncated size, after a full element
+	e, err = RootElem(bytes.NewBufferString(sb(0x81, 0x80, 0x82, 0x40))).Next()
+	assert.Nil(err)
+	_, err = e.Next()
+	assert.True(errors.As(err, &perr))
+	assert.Equal(int64(2), perr.Offset)
+	assert.True(errors.Is(err, Truncated))
+
+	// Container ending before its size says, after a full child
+	e, err = RootElem(bytes.NewBufferString(sb(0x81, 0xa0, 0x82, 0x81, 0x01))).Next()
+	assert.Nil(err)
+	e, err = e.Next()
+	assert.Nil(err)
+	_, err = e.Uint()
+	assert.Nil(err)
+	_, err = e.Next()
+	assert.True(errors.As(err, &perr))
+	assert.Equal(int64(0), perr.Offset)
+	assert.Exactly(varint.VarInt(0x81), perr.ElementID)
+	assert.True(errors.Is(err, Truncated))
+
+	// Invalid id
+	_, err = RootElem(bytes.NewBufferString(sb(0x00, 0x80))).Next()
+	assert.True(errors.Is(err, varint.InvalidVarInt))
+
+	// Clean end of stream
+	_, err = RootElem(bytes.NewBufferString("")).Next()
+	assert.Equal(io.EOF, err)
+}
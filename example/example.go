@@ -28,8 +28,7 @@ func main() {
 
 	log.Printf("starting parswer for %s", os.Args[1])
 	p := e.NewParser(f)
-	for {
-		el, err := p.Next()
+	for el, err := range p.All() {
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -59,6 +58,9 @@ func main() {
 		case edtd.String:
 			thing, err = el.Str()
 			line = fmt.Sprintf("%s - %s", prefix, thing)
+		case edtd.UTF8:
+			thing, err = el.UTF8()
+			line = fmt.Sprintf("%s - %s", prefix, thing)
 		default:
 			line = prefix
 		}
@@ -0,0 +1,96 @@
+package ebmlstream
+
+import (
+	"io"
+	"iter"
+)
+
+// Returns an iterator over all Elems following this one in the stream, as
+// returned by Next(). Iteration stops once the stream ends cleanly, any other
+// error is yielded once before stopping. The same rules apply as when calling
+// Next() directly: each non-container Elem must have its data read (or be
+// skipped) in the body of the loop.
+//
+//	for e, err := range ebmlstream.RootElem(r).All() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (e *Elem) All() iter.Seq2[*Elem, error] {
+	return func(yield func(*Elem, error) bool) {
+		for {
+			el, err := e.Next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			} else if !yield(el, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Returns an iterator over the direct children of the container Elem, which
+// must not have been read past yet. If called on the root Elem the top-level
+// elements are iterated over.
+//
+// A child container which is left untouched by the body of the loop is
+// skipped over in its entirety, unless it's of unknown size, in which case its
+// children are skipped one at a time. Since a container of unknown size only
+// ends along with a container of known size or as decided by EndFunc
+// (see Ended), the Elem which ends it is read but then put back to be returned
+// by the next call to Next().
+func (e *Elem) Children() iter.Seq2[*Elem, error] {
+	return func(yield func(*Elem, error) bool) {
+		isRoot := e.HeaderSize == 0
+		end, known := e.End()
+
+		for {
+			if known && e.s.pending == nil && e.s.pos >= end {
+				return
+			}
+
+			el, err := e.Next()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			if !isRoot && !el.inside(e) {
+				e.s.pending = el
+				return
+			} else if el.Depth > e.Depth+1 || (isRoot && el.Depth > 0) {
+				// A descendant of a child which was only partly read
+				if err := el.Skip(); err != nil && !el.UnknownSize {
+					yield(nil, err)
+					return
+				}
+				continue
+			}
+
+			if !yield(el, nil) {
+				return
+			} else if el.entered() && !el.UnknownSize {
+				if err := el.Skip(); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Returns true if the Elem is a descendant of the container
+func (e *Elem) inside(c *Elem) bool {
+	for p := e.Parent; p != nil; p = p.Parent {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "testing"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+func TestElemIter(t *T) {
+	in := sb(
+		0x81, 0x87,
+		0x82, 0x83,
+		0x83, 0x81, 0x01,
+		0x84, 0x80,
+		0x85, 0x80,
+	)
+	collect := func(seq func(func(*Elem, error) bool), read bool) []varint.VarInt {
+		var ids []varint.VarInt
+		seq(func(e *Elem, err error) bool {
+			require.Nil(t, err)
+			ids = append(ids, e.Id)
+			if read && e.Id > 0x82 {
+				_, err := e.Bytes()
+				require.Nil(t, err)
+			}
+			return true
+		})
+		return ids
+	}
+
+	root := RootElem(bytes.NewBufferString(in))
+	assert.Equal(t, []varint.VarInt{0x81, 0x82, 0x83, 0x84, 0x85}, collect(root.All(), true))
+
+	root = RootElem(bytes.NewBufferString(in))
+	for e, err := range root.All() {
+		require.Nil(t, err)
+		if e.Id == 0x82 {
+			break
+		}
+	}
+	e, err := root.Next()
+	require.Nil(t, err)
+	assert.Equal(t, varint.VarInt(0x83), e.Id)
+
+	root = RootElem(bytes.NewBufferString(in))
+	assert.Equal(t, []varint.VarInt{0x81, 0x85}, collect(root.Children(), false))
+
+	root = RootElem(bytes.NewBufferString(in))
+	e, err = root.Next()
+	require.Nil(t, err)
+	assert.Equal(t, []varint.VarInt{0x82, 0x84}, collect(e.Children(), true))
+	e, err = root.Next()
+	require.Nil(t, err)
+	assert.Equal(t, varint.VarInt(0x85), e.Id)
+}
+
+func TestElemIterUnknown(t *T) {
+	in := sb(
+		0x81, 0xff,
+		0x82, 0x81, 0x01,
+		0x81, 0xff,
+		0x82, 0x81, 0x02,
+	)
+	root := RootElem(bytes.NewBufferString(in))
+	root.EndFunc(func(c, next *Elem) bool { return next.Id == 0x81 })
+
+	e, err := root.Next()
+	require.Nil(t, err)
+	var vals []uint64
+	for child, err := range e.Children() {
+		require.Nil(t, err)
+		u, err := child.Uint()
+		require.Nil(t, err)
+		vals = append(vals, u)
+	}
+	assert.Equal(t, []uint64{1}, vals)
+
+	e, err = root.Next()
+	require.Nil(t, err)
+	assert.Equal(t, varint.VarInt(0x81), e.Id)
+	assert.Equal(t, int64(5), e.Offset)
+	assert.Len(t, e.Ended, 1)
+}
@@ -0,0 +1,401 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// Schema is used by MarshalSchema and UnmarshalSchema to look up the element
+// names used in struct tags, and the default values of elements. It's
+// implemented by edtd.Edtd
+type Schema interface {
+	// Returns the id of the element with the given name, inside of the
+	// container with the given id (0 for top-level elements). Since a name can
+	// be used by more than one element in different containers the parent is
+	// used to tell them apart, if it can't be an error is returned. If there's
+	// no element with the name the error is, or wraps, UnknownElement
+	ElementID(parent varint.VarInt, name string) (varint.VarInt, error)
+
+	// Returns the encoded default data of the element with the given id, if
+	// it has one
+	DefaultData(id varint.VarInt) ([]byte, bool)
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// A struct field which has an ebml tag
+type field struct {
+	index     int
+	id        varint.VarInt
+	omitEmpty bool
+}
+
+// Returns the fields of the struct type which have an ebml tag. A tag is either
+// the id of the element in hex (e.g. `ebml:"4286"`) or, if a Schema is given,
+// the element's name (e.g. `ebml:"EBMLVersion"`), which is looked up within
+// the container with the parent id. It can be followed by ",omitempty", in
+// which case the field isn't marshaled if it has its zero value. A tag of "-"
+// is ignored.
+func structFields(t reflect.Type, parent varint.VarInt, s Schema) ([]field, error) {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("ebml")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		f := field{index: i}
+		for _, opt := range opts[1:] {
+			if opt != "omitempty" {
+				return nil, fmt.Errorf("field %s: unknown tag option %q", sf.Name, opt)
+			}
+			f.omitEmpty = true
+		}
+
+		if id, err := schemaID(s, parent, opts[0]); err == nil {
+			f.id = id
+		} else if !errors.Is(err, UnknownElement) {
+			return nil, fmt.Errorf("field %s: %w", sf.Name, err)
+		} else if id, err := strconv.ParseUint(opts[0], 16, 64); err == nil {
+			f.id = varint.VarInt(id)
+		} else {
+			return nil, fmt.Errorf("field %s: unknown element %q", sf.Name, opts[0])
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func schemaID(s Schema, parent varint.VarInt, name string) (varint.VarInt, error) {
+	if s == nil {
+		return 0, UnknownElement
+	}
+	return s.ElementID(parent, name)
+}
+
+// Returns the ebml encoding of v, which must be a struct (or a pointer to one).
+// Each tagged field of the struct (see below) is written out as a top-level
+// element. Fields are encoded according to their type:
+//
+//	uint types, bool  -> uint elements (bools as 0 or 1)
+//	int types         -> int elements
+//	float32, float64  -> 4 and 8 byte float elements
+//	string            -> string elements
+//	time.Time         -> date elements
+//	[]byte            -> binary elements
+//	structs           -> containers, whose children are the struct's fields
+//	pointers          -> the value pointed to, or nothing if nil
+//	slices            -> one element for each item in the slice
+//
+// The tag of a field is the element's id in hex, e.g. `ebml:"4286"`, and can be
+// followed by ",omitempty" to not write the field if it has its zero value.
+// Fields without a tag are ignored.
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalSchema(v, nil)
+}
+
+// Like Marshal, but the struct tags can also contain element names, which are
+// looked up in the Schema. The fields of v are taken to be top-level elements,
+// and the fields of structs within it the children of the field's container.
+func MarshalSchema(v interface{}, s Schema) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can't marshal %T, must be a struct", v)
+	}
+	nodes, err := marshalStruct(rv, 0, s)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	for _, n := range nodes {
+		if _, err := n.WriteTo(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the Nodes for all of the struct's tagged fields, which are the
+// children of the container with the parent id
+func marshalStruct(rv reflect.Value, parent varint.VarInt, s Schema) ([]*Node, error) {
+	fields, err := structFields(rv.Type(), parent, s)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		fnodes, err := marshalValue(f.id, fv, s)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, fnodes...)
+	}
+	return nodes, nil
+}
+
+// Returns the Nodes the value is encoded as, see Marshal
+func marshalValue(id varint.VarInt, v reflect.Value, s Schema) ([]*Node, error) {
+	n := &Node{Id: id}
+	switch v.Type() {
+	case timeType:
+		n.Value = v.Interface().(time.Time)
+		return []*Node{n}, nil
+	case bytesType:
+		n.Value = v.Bytes()
+		if n.Value == nil {
+			n.Value = []byte{}
+		}
+		return []*Node{n}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(id, v.Elem(), s)
+	case reflect.Slice:
+		var nodes []*Node
+		for i := 0; i < v.Len(); i++ {
+			inodes, err := marshalValue(id, v.Index(i), s)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, inodes...)
+		}
+		return nodes, nil
+	case reflect.Struct:
+		children, err := marshalStruct(v, id, s)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = children
+	case reflect.Bool:
+		n.Value = uint64(0)
+		if v.Bool() {
+			n.Value = uint64(1)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n.Value = v.Uint()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n.Value = v.Int()
+	case reflect.Float32:
+		n.Value = float32(v.Float())
+	case reflect.Float64:
+		n.Value = v.Float()
+	case reflect.String:
+		n.Value = v.String()
+	default:
+		return nil, fmt.Errorf("can't marshal %s into element %x", v.Type(), id)
+	}
+	return []*Node{n}, nil
+}
+
+// Reads the ebml elements in b into v, which must be a pointer to a struct.
+// Each top-level element is read into the struct field tagged with its id (see
+// Marshal for how types correspond), elements with no such field are skipped.
+// If a field's type is a slice each element is appended to it, otherwise the
+// last element is the one used. Pointer fields are allocated if their element
+// is present and left untouched otherwise.
+//
+// Containers of unknown size can't be read into a struct, SizeUnknown is
+// returned for them.
+func Unmarshal(b []byte, v interface{}) error {
+	return UnmarshalSchema(b, v, nil)
+}
+
+// Like Unmarshal, but the struct tags can also contain element names, which are
+// looked up in the Schema. Also, any non-pointer, non-slice fields whose
+// elements aren't present are set to the element's default value, if the
+// Schema has one.
+func UnmarshalSchema(b []byte, v interface{}, s Schema) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't unmarshal into %T, must be a pointer to a struct", v)
+	}
+	return unmarshalStruct(b, rv.Elem(), 0, s)
+}
+
+// Reads the elements in b, which are the children of the container with the
+// parent id, into the struct
+func unmarshalStruct(b []byte, rv reflect.Value, parent varint.VarInt, s Schema) error {
+	fields, err := structFields(rv.Type(), parent, s)
+	if err != nil {
+		return err
+	}
+	byID := map[varint.VarInt]int{}
+	for i, f := range fields {
+		byID[f.id] = i
+	}
+	seen := make([]bool, len(fields))
+
+	e := RootElem(bytes.NewReader(b))
+	for {
+		if e, err = e.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		i, ok := byID[e.Id]
+		if !ok {
+			if err := e.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fv := rv.Field(fields[i].index)
+		if !seen[i] && fv.Kind() == reflect.Slice && fv.Type() != bytesType {
+			fv.Set(fv.Slice(0, 0))
+		}
+		seen[i] = true
+		if err := unmarshalValue(e, fv, s); err != nil {
+			return err
+		}
+	}
+
+	if s == nil {
+		return nil
+	}
+	for i, f := range fields {
+		fv := rv.Field(f.index)
+		if seen[i] || !hasDefault(fv.Type()) {
+			continue
+		}
+		data, ok := s.DefaultData(f.id)
+		if !ok {
+			continue
+		}
+		e, err := dataElem(f.id, data)
+		if err != nil {
+			return err
+		} else if err := unmarshalValue(e, fv, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if fields of the given type can be set to a default value
+func hasDefault(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return false
+	case reflect.Slice:
+		return t == bytesType
+	case reflect.Struct:
+		return t == timeType
+	}
+	return true
+}
+
+// Returns an Elem with the given id and data, which has already been read
+func dataElem(id varint.VarInt, data []byte) (*Elem, error) {
+	size, err := varint.Encode(uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	id.WriteTo(buf)
+	size.WriteTo(buf)
+	buf.Write(data)
+	return RootElem(buf).Next()
+}
+
+// Reads the Elem's data into v, see Unmarshal
+func unmarshalValue(e *Elem, v reflect.Value, s Schema) error {
+	switch v.Type() {
+	case timeType:
+		t, err := e.Date()
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case bytesType:
+		b, err := e.Bytes()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(b)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		nv := reflect.New(v.Type().Elem())
+		if err := unmarshalValue(e, nv.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(nv)
+	case reflect.Slice:
+		nv := reflect.New(v.Type().Elem()).Elem()
+		if err := unmarshalValue(e, nv, s); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, nv))
+	case reflect.Struct:
+		b, err := e.Bytes()
+		if err != nil {
+			return err
+		}
+		return unmarshalStruct(b, v, e.Id, s)
+	case reflect.Bool:
+		u, err := e.Uint()
+		if err != nil {
+			return err
+		}
+		v.SetBool(u != 0)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := e.Uint()
+		if err != nil {
+			return err
+		} else if v.OverflowUint(u) {
+			return fmt.Errorf("value %d of element %x overflows %s", u, e.Id, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := e.Int()
+		if err != nil {
+			return err
+		} else if v.OverflowInt(i) {
+			return fmt.Errorf("value %d of element %x overflows %s", i, e.Id, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := e.Float()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		str, err := e.UTF8()
+		if err != nil {
+			return err
+		}
+		v.SetString(str)
+	default:
+		return fmt.Errorf("can't unmarshal element %x into %s", e.Id, v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package ebmlstream
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "testing"
+	"time"
+)
+
+type testMarshalInner struct {
+	A uint16 `ebml:"83"`
+	B []int  `ebml:"84"`
+}
+
+type testMarshalOuter struct {
+	Inner    testMarshalInner   `ebml:"81"`
+	Repeated []testMarshalInner `ebml:"82"`
+	Opt      *string            `ebml:"85"`
+	Empty    string             `ebml:"86,omitempty"`
+	Flag     bool               `ebml:"87"`
+	F        float32            `ebml:"88"`
+	D        time.Time          `ebml:"89"`
+	Data     []byte             `ebml:"8a"`
+	Ignored  string
+}
+
+func TestMarshal(t *T) {
+	v := testMarshalOuter{
+		Inner:    testMarshalInner{A: 1, B: []int{-1, 2}},
+		Repeated: []testMarshalInner{{A: 2}, {A: 3}},
+		Flag:     true,
+		F:        1,
+		D:        DateEpoch,
+		Data:     []byte{1, 2},
+		Ignored:  "foo",
+	}
+	b, err := Marshal(&v)
+	require.Nil(t, err)
+
+	expect := []byte{
+		0x81, 0x89,
+		0x83, 0x81, 0x01,
+		0x84, 0x81, 0xff,
+		0x84, 0x81, 0x02,
+		0x82, 0x83, 0x83, 0x81, 0x02,
+		0x82, 0x83, 0x83, 0x81, 0x03,
+		0x87, 0x81, 0x01,
+		0x88, 0x84, 0x3f, 0x80, 0x00, 0x00,
+		0x89, 0x88, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x8a, 0x82, 0x01, 0x02,
+	}
+	assert.Equal(t, expect, b)
+
+	var out testMarshalOuter
+	require.Nil(t, Unmarshal(b, &out))
+	v.Ignored = ""
+	assert.Equal(t, v, out)
+
+	str := "hi"
+	v.Opt = &str
+	b, err = Marshal(v)
+	require.Nil(t, err)
+	out = testMarshalOuter{}
+	require.Nil(t, Unmarshal(b, &out))
+	require.NotNil(t, out.Opt)
+	assert.Equal(t, "hi", *out.Opt)
+}
+
+func TestUnmarshalErrors(t *T) {
+	var s struct {
+		A uint8 `ebml:"81"`
+	}
+	assert.NotNil(t, Unmarshal([]byte{0x81, 0x82, 0x01, 0x00}, &s))
+	assert.NotNil(t, Unmarshal([]byte{0x81, 0x81, 0x01}, s))
+
+	var bad struct {
+		A uint8 `ebml:"nope"`
+	}
+	assert.NotNil(t, Unmarshal([]byte{0x81, 0x81, 0x01}, &bad))
+}
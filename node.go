@@ -0,0 +1,223 @@
+package ebmlstream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// Node is a single element in an in-memory tree of ebml elements. Unlike an
+// Elem, a Node holds all of its data (and all of its children) at once, and
+// can be freely modified and then written back out using WriteTo, which works
+// out the sizes of everything again.
+//
+// A tree of Nodes can be built from a raw stream using ReadNodes, or from an
+// edtd Parser, which is able to fill in typed values.
+type Node struct {
+	Id varint.VarInt
+
+	// The value of a non-container Node. Must be one of uint64, int64,
+	// float32, float64, string, time.Time or []byte. A nil Value means the
+	// Node is a container
+	Value interface{}
+
+	// The children of a container Node, in the order they're written
+	Children []*Node
+}
+
+// Returns true if the Node is a container, i.e. it has no Value
+func (n *Node) IsContainer() bool {
+	return n.Value == nil
+}
+
+// Returns the first direct child of the Node with the given id, or nil
+func (n *Node) Child(id varint.VarInt) *Node {
+	for _, c := range n.Children {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// Follows the given ids down through the tree, each being the id of a child of
+// the previous one, and returns the Node at the end of the path. The first
+// matching child is always used. Returns nil if there is no such Node
+func (n *Node) Find(path ...varint.VarInt) *Node {
+	for _, id := range path {
+		if n = n.Child(id); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// Returns all Nodes in the tree under this one (not including this one) with
+// the given id, in the order they'd be written
+func (n *Node) FindAll(id varint.VarInt) []*Node {
+	var found []*Node
+	for _, c := range n.Children {
+		if c.Id == id {
+			found = append(found, c)
+		}
+		found = append(found, c.FindAll(id)...)
+	}
+	return found
+}
+
+// Inserts the child Node at index i of the Node's children. If i is out of
+// range the child is appended instead
+func (n *Node) Insert(i int, c *Node) {
+	if i < 0 || i >= len(n.Children) {
+		n.Children = append(n.Children, c)
+		return
+	}
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = c
+}
+
+// Removes the given direct child from the Node. Returns false if it wasn't
+// a child of the Node
+func (n *Node) Remove(c *Node) bool {
+	for i := range n.Children {
+		if n.Children[i] == c {
+			n.Children = append(n.Children[:i], n.Children[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces the given direct child of the Node with a new one, in the same
+// position. Returns false if old wasn't a child of the Node
+func (n *Node) Replace(old, new *Node) bool {
+	for i := range n.Children {
+		if n.Children[i] == old {
+			n.Children[i] = new
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the encoded form of the Node's value
+func (n *Node) encodeValue() ([]byte, error) {
+	switch v := n.Value.(type) {
+	case uint64:
+		return EncodeUint(v), nil
+	case int64:
+		return EncodeInt(v), nil
+	case float32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(v))
+		return b, nil
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		return b, nil
+	case string:
+		return []byte(v), nil
+	case time.Time:
+		return EncodeDate(v)
+	case []byte:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("invalid value type %T for element %x", v, n.Id)
+	}
+}
+
+// Writes the Node, and all of its children, to the Encoder
+func (n *Node) Encode(enc *Encoder) error {
+	if !n.IsContainer() {
+		b, err := n.encodeValue()
+		if err != nil {
+			return err
+		}
+		return enc.put(n.Id, b)
+	}
+
+	if err := enc.StartBufferedContainer(n.Id); err != nil {
+		return err
+	}
+	for _, c := range n.Children {
+		if err := c.Encode(enc); err != nil {
+			return err
+		}
+	}
+	return enc.EndContainer()
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Writes the Node, and all of its children, to the io.Writer as ebml elements.
+// The sizes of all containers are calculated from their children, so the tree
+// can be modified freely beforehand
+func (n *Node) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := n.Encode(NewStreamEncoder(cw))
+	return cw.n, err
+}
+
+// Reads all remaining elements from the stream into trees of Nodes, one for
+// each top-level element. Since the raw stream doesn't say which elements are
+// containers isContainer is used to decide; the Values of all other Nodes are
+// their raw data as []byte (an edtd Parser can be used to get typed values
+// instead). Containers of unknown size can't be read this way, and cause
+// SizeUnknown to be returned.
+func ReadNodes(r io.Reader, isContainer func(varint.VarInt) bool) ([]*Node, error) {
+	type openNode struct {
+		*Node
+		end int64
+	}
+	var roots []*Node
+	var open []openNode
+
+	e := RootElem(r)
+	for {
+		var err error
+		if e, err = e.Next(); err == io.EOF {
+			return roots, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		for len(open) > 0 && e.Offset >= open[len(open)-1].end {
+			open = open[:len(open)-1]
+		}
+
+		n := &Node{Id: e.Id}
+		if len(open) == 0 {
+			roots = append(roots, n)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, n)
+		}
+
+		if !isContainer(e.Id) {
+			if n.Value, err = e.Bytes(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		end, ok := e.End()
+		if !ok {
+			return nil, SizeUnknown
+		}
+		open = append(open, openNode{Node: n, end: end})
+	}
+}
@@ -0,0 +1,79 @@
+package ebmlstream
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	. "testing"
+	"time"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+func TestReadNodes(t *T) {
+	in := []byte{
+		0x81, 0x88,
+		0x82, 0x81, 0x01,
+		0x83, 0x83,
+		0x84, 0x81, 0x02,
+		0x85, 0x80,
+	}
+	isContainer := func(id varint.VarInt) bool { return id == 0x81 || id == 0x83 }
+	nodes, err := ReadNodes(bytes.NewReader(in), isContainer)
+	require.Nil(t, err)
+	require.Len(t, nodes, 2)
+
+	root := nodes[0]
+	assert.True(t, root.IsContainer())
+	assert.Equal(t, []byte{0x01}, root.Child(0x82).Value)
+	assert.Equal(t, []byte{0x02}, root.Find(0x83, 0x84).Value)
+	assert.Nil(t, root.Find(0x83, 0x82))
+	assert.Equal(t, []byte{}, nodes[1].Value)
+
+	buf := new(bytes.Buffer)
+	for _, n := range nodes {
+		_, err := n.WriteTo(buf)
+		require.Nil(t, err)
+	}
+	assert.Equal(t, in, buf.Bytes())
+}
+
+func TestNodeEdit(t *T) {
+	root := &Node{Id: 0x81, Children: []*Node{
+		{Id: 0x82, Value: uint64(1)},
+		{Id: 0x83, Children: []*Node{
+			{Id: 0x84, Value: "hi"},
+		}},
+	}}
+
+	c := &Node{Id: 0x85, Value: int64(-1)}
+	root.Insert(1, c)
+	root.Find(0x83).Insert(-1, &Node{Id: 0x84, Value: float32(1)})
+	assert.Len(t, root.FindAll(0x84), 2)
+
+	assert.True(t, root.Replace(root.Child(0x82), &Node{
+		Id:    0x86,
+		Value: DateEpoch.Add(time.Nanosecond),
+	}))
+	assert.True(t, root.Find(0x83).Remove(root.Find(0x83, 0x84)))
+	assert.False(t, root.Remove(&Node{Id: 0x84}))
+
+	n, err := root.WriteTo(new(bytes.Buffer))
+	require.Nil(t, err)
+	assert.Equal(t, int64(23), n)
+
+	buf := new(bytes.Buffer)
+	_, err = root.WriteTo(buf)
+	require.Nil(t, err)
+	expect := []byte{
+		0x81, 0x95,
+		0x86, 0x88, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		0x85, 0x81, 0xff,
+		0x83, 0x86,
+		0x84, 0x84, 0x3f, 0x80, 0x00, 0x00,
+	}
+	assert.Equal(t, expect, buf.Bytes())
+
+	_, err = (&Node{Id: 0x81, Value: 1}).WriteTo(buf)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,296 @@
+package ebmlstream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/mediocregopher/ebmlstream/varint"
+)
+
+// stream is shared by all Elems read off of the same io.Reader. It wraps the
+// io.Reader in a bufio.Reader, and keeps track of how many bytes have been
+// consumed from it so far
+type stream struct {
+	r   io.Reader
+	buf *bufio.Reader
+	pos int64
+
+	// Set if r is an io.Seeker which can actually seek, which isn't the case
+	// for e.g. an *os.File of a pipe
+	seeker io.Seeker
+
+	// The last Elem read, the containers which are currently open (outermost
+	// first), and the function deciding when they end early, see EndFunc
+	last    *Elem
+	open    []*Elem
+	endFunc func(container, next *Elem) bool
+
+	// Set by VerifyCRC32, and the CRC32 containers being verified, innermost
+	// last
+	verifyCRC bool
+	crcs      []*crcCheck
+
+	// An Elem which was read but put back, to be returned by the next call to
+	// Next()
+	pending *Elem
+
+	// The limits on the stream, and the number of Elems read from it so far
+	opts  ReaderOptions
+	count int
+
+	// If set, reading from the stream stops once this is done
+	ctx context.Context
+}
+
+func newStream(r io.Reader) *stream {
+	return &stream{
+		r:      r,
+		buf:    bufio.NewReader(r),
+		seeker: seekerOf(r),
+	}
+}
+
+// Like newStream, but for an io.Reader which starts at pos within some larger
+// stream. The bufio.Reader will use the given buffer size
+func newStreamAt(r io.Reader, pos int64, size int) *stream {
+	return &stream{
+		r:      r,
+		buf:    bufio.NewReaderSize(r, size),
+		pos:    pos,
+		seeker: seekerOf(r),
+	}
+}
+
+// Returns the io.Reader as an io.Seeker, if it is one and seeking on it works
+func seekerOf(r io.Reader) io.Seeker {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return nil
+	} else if _, err := seeker.Seek(0, io.SeekCurrent); err != nil {
+		return nil
+	}
+	return seeker
+}
+
+// Returns the error of the stream's context, if it has one and it's done
+func (s *stream) ctxErr() error {
+	if s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+// Implements io.Reader
+func (s *stream) Read(b []byte) (int, error) {
+	if err := s.ctxErr(); err != nil {
+		return 0, err
+	}
+
+	if max := s.opts.MaxTotalBytes; max > 0 {
+		if s.pos >= max {
+			// Hitting the limit right where the stream ends is fine
+			if _, err := s.buf.Peek(1); err != nil {
+				return 0, err
+			}
+			return 0, s.totalBytesErr()
+		} else if rem := max - s.pos; int64(len(b)) > rem {
+			b = b[:rem]
+		}
+	}
+
+	n, err := s.buf.Read(b)
+	s.hashData(b[:n])
+	s.pos += int64(n)
+	return n, err
+}
+
+// Implements io.ByteReader, so that varints can be read off the stream without
+// allocating. This is only used for element headers, which are added to
+// CRC-32s separately (see hashHeader)
+func (s *stream) ReadByte() (byte, error) {
+	if err := s.ctxErr(); err != nil {
+		return 0, err
+	} else if max := s.opts.MaxTotalBytes; max > 0 && s.pos >= max {
+		if _, err := s.buf.Peek(1); err != nil {
+			return 0, err
+		}
+		return 0, s.totalBytesErr()
+	}
+
+	c, err := s.buf.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.pos++
+	return c, nil
+}
+
+// Skips over the next n bytes in the stream. If the underlying io.Reader is an
+// io.Seeker and n goes past what is currently buffered then it will be used to
+// seek past the bytes instead of reading them. If CRC-32s are being calculated
+// the bytes are always read, so they can be added to them. If the stream ends before n
+// bytes have been skipped io.EOF or io.ErrUnexpectedEOF is returned
+func (s *stream) skip(n int64) error {
+	if max := s.opts.MaxTotalBytes; max > 0 && s.pos+n > max {
+		return s.totalBytesErr()
+	} else if err := s.ctxErr(); err != nil {
+		return err
+	}
+
+	if len(s.crcs) > 0 {
+		_, err := io.CopyN(io.Discard, s, n)
+		return err
+	}
+
+	if s.seeker != nil && n > int64(s.buf.Buffered()) {
+		return s.seekSkip(n)
+	}
+
+	// bufio's Discard takes an int, so this loops in case n is larger than
+	// that. If there's a context the loop uses smaller chunks, so that it can
+	// be checked regularly
+	maxChunk := int64(math.MaxInt32)
+	if s.ctx != nil {
+		maxChunk = ctxChunkSize
+	}
+	for n > 0 {
+		if err := s.ctxErr(); err != nil {
+			return err
+		}
+		chunk := n
+		if chunk > maxChunk {
+			chunk = maxChunk
+		}
+		d, err := s.buf.Discard(int(chunk))
+		n -= int64(d)
+		s.pos += int64(d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Skips over the next n bytes by seeking past them. Seeking beyond the end of
+// the underlying io.Reader is allowed by io.Seeker, so the end is found first,
+// and if it comes before the n bytes do the stream is left there and
+// io.ErrUnexpectedEOF is returned
+func (s *stream) seekSkip(n int64) error {
+	// The underlying io.Reader is ahead of the stream by however much is
+	// buffered
+	cur, err := s.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := s.seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	buffered := int64(s.buf.Buffered())
+	target := cur + n - buffered
+	if target > end {
+		target = end
+	}
+	if _, err := s.seeker.Seek(target, io.SeekStart); err != nil {
+		return err
+	}
+	s.buf.Reset(s.r)
+
+	skipped := buffered + target - cur
+	s.pos += skipped
+	if skipped < n {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// The most bytes skipped over between checks of the stream's context
+const ctxChunkSize = 64 * 1024
+
+// Ends any open containers which the newly read Elem can't be inside of, and
+// fills in the Elem's Parent, Depth and Ended
+func (s *stream) track(el *Elem) {
+	s.last = el
+
+	for i, c := range s.open {
+		if end, ok := c.End(); ok && el.Offset >= end {
+			for j := len(s.open) - 1; j >= i; j-- {
+				el.Ended = append(el.Ended, s.open[j])
+			}
+			s.open = s.open[:i]
+			break
+		}
+	}
+
+	for len(s.open) > 0 && s.endFunc != nil {
+		c := s.open[len(s.open)-1]
+		if !s.endFunc(c, el) {
+			break
+		}
+		el.Ended = append(el.Ended, c)
+		s.open = s.open[:len(s.open)-1]
+	}
+
+	el.Depth = len(s.open)
+	if el.Depth > 0 {
+		el.Parent = s.open[el.Depth-1]
+	}
+}
+
+// Called when the stream ends at pos, where the next Elem would have started.
+// Everything still open ends along with the stream, so if any of it is of known
+// size and should end after pos the stream has been truncated, and a
+// *ParseError for the innermost such container is returned. Otherwise io.EOF
+// is, unless a CRC32 container fails its check (see endCRCs)
+func (s *stream) end(pos int64) error {
+	open := s.open
+	s.open = nil
+	for i := len(open) - 1; i >= 0; i-- {
+		if end, ok := open[i].End(); ok && end > pos {
+			return parseErr(open[i].Offset, open[i].Id, io.ErrUnexpectedEOF)
+		}
+	}
+	if err := s.endCRCs(pos); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (s *stream) totalBytesErr() error {
+	return fmt.Errorf(
+		"%w: more than MaxTotalBytes (%d) bytes",
+		LimitExceeded, s.opts.MaxTotalBytes,
+	)
+}
+
+// Looks at the upcoming bytes in the stream, without consuming them, and
+// returns the id of the element header they would make up. Returns false if
+// they don't make up a valid header (or there aren't enough of them)
+func (s *stream) peekHeader() (varint.VarInt, bool) {
+	id, n, ok := s.peekVarInt(0)
+	if !ok {
+		return 0, false
+	}
+	_, _, ok = s.peekVarInt(n)
+	return id, ok
+}
+
+// Decodes the varint which starts off bytes into the upcoming bytes in the
+// stream, without consuming them, and returns it along with the offset it ends
+// at. Bytes are peeked at one more at a time, so that on a live stream this
+// doesn't wait on any bytes past the varint
+func (s *stream) peekVarInt(off int) (varint.VarInt, int, bool) {
+	for n := off + 1; ; n++ {
+		b, err := s.buf.Peek(n)
+		v, width, derr := varint.Decode(b[off:])
+		if derr == nil {
+			return v, off + width, true
+		} else if derr != io.ErrUnexpectedEOF || err != nil {
+			return 0, 0, false
+		}
+	}
+}
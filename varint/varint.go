@@ -7,7 +7,6 @@
 package varint
 
 import (
-	"bytes"
 	"errors"
 	"io"
 )
@@ -19,12 +18,13 @@ var (
 
 const (
 	// The largest unsigned integer which can be represented with the VarInt
-	// data type
-	MaxEncodable = uint64(0xffffffffffffff)
+	// data type. The value with all data bits set is reserved at every width
+	// (see Unknown), so this is one less than what 8 bytes could hold
+	MaxEncodable = uint64(0xfffffffffffffe)
 
-	// The minimum and maximum raw varints which can exist. The represent
-	// MaxEncodable and 0, respectively
-	maxRaw = VarInt((MaxEncodable + 1) | MaxEncodable)
+	// The minimum and maximum raw varints which can exist. The represent 0 and
+	// the 8 byte reserved value, respectively
+	maxRaw = VarInt(0x01ffffffffffffff)
 	minRaw = VarInt(0x80)
 )
 
@@ -40,27 +40,53 @@ func numPrecedingZeros(b byte) byte {
 	return 8
 }
 
-func readByte(r io.Reader) (byte, error) {
-	b := make([]byte, 1)
-	_, err := io.ReadFull(r, b)
-	return b[0], err
-}
-
 // Reads an encoded variable integer from the given reader, reading only as many
 // bytes as necessary. This will keep the VarInt exactly as it was read, even if
 // the form it was read in was not as compact as possible. Use Normalize() to
-// compact and existing VarInt
+// compact and existing VarInt. If the reader is also an io.ByteReader (e.g.
+// a bufio.Reader) it's read from a byte at a time, without allocating.
+//
+// If the reader is empty io.EOF is returned, but if it ends partway through the
+// VarInt io.ErrUnexpectedEOF is. A first byte of zero is not valid, since
+// VarInts are at most 8 bytes, and returns InvalidVarInt.
 func Read(r io.Reader) (VarInt, error) {
-	b, err := readByte(r)
+	if br, ok := r.(io.ByteReader); ok {
+		return readByteReader(br)
+	}
+
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, err
+	}
+	n := int(numPrecedingZeros(b[0])) + 1
+	if n > 8 {
+		return 0, InvalidVarInt
+	}
+	if _, err := io.ReadFull(r, b[1:n]); err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	v, _, err := Decode(b[:n])
+	return v, err
+}
+
+func readByteReader(br io.ByteReader) (VarInt, error) {
+	b, err := br.ReadByte()
 	if err != nil {
 		return 0, err
 	}
 
 	rem := numPrecedingZeros(b)
+	if rem == 8 {
+		return 0, InvalidVarInt
+	}
 	ret := uint64(b)
 	for ; rem > 0; rem-- {
-		b, err = readByte(r)
-		if err != nil {
+		b, err = br.ReadByte()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
 			return 0, err
 		}
 		ret = (ret << 8) | uint64(b)
@@ -69,46 +95,70 @@ func Read(r io.Reader) (VarInt, error) {
 	return VarInt(ret), nil
 }
 
-// Same as Read, but reads from an existing byte slice (without modifying the
-// slice) The slice of bytes must have enough bytes to encompass the full
-// varint, but having more bytes than necessary is ok
+// Decodes the VarInt at the start of the given slice, without allocating.
+// Returns the VarInt, kept exactly as it was encoded like with Read, and the
+// number of bytes it took up. Any bytes after the VarInt are ignored.
+//
+// If the slice is empty io.EOF is returned, and if it ends partway through the
+// VarInt io.ErrUnexpectedEOF is. A first byte of zero returns InvalidVarInt.
+func Decode(b []byte) (VarInt, int, error) {
+	if len(b) == 0 {
+		return 0, 0, io.EOF
+	}
+	n := int(numPrecedingZeros(b[0])) + 1
+	if n > 8 {
+		return 0, 0, InvalidVarInt
+	} else if len(b) < n {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+
+	var ret uint64
+	for _, c := range b[:n] {
+		ret = (ret << 8) | uint64(c)
+	}
+	return VarInt(ret), n, nil
+}
+
+// Parses the VarInt at the start of the given slice, see Decode
 func Parse(b []byte) (VarInt, error) {
-	buf := bytes.NewBuffer(b)
-	return Read(buf)
+	v, _, err := Decode(b)
+	return v, err
 }
 
-// Encodes the given integer into the smallest possible VarInt
+// Encodes the given integer into the smallest possible VarInt. The reserved
+// value for a width (see Unknown) is never used, the next width is used instead
 func Encode(i uint64) (VarInt, error) {
-	if i > MaxEncodable {
-		return 0, IntegerTooBig
-	}
-
-	// Count the number of bits actually being used by the number
-	bits := byte(0)
-	for ic := i; ; {
-		ic >>= 1
-		bits++
-		if ic == 0 {
-			break
+	for width := 1; width <= 8; width++ {
+		if v, err := EncodeWidth(i, width); err == nil {
+			return v, nil
 		}
 	}
+	return 0, IntegerTooBig
+}
 
-	// Find the number of bytes the raw number uses. We round up using the cool
-	// addition hack
-	bytes := (bits + 7) / 8
-
-	// If we have enough bits available at the front of the raw number to simply
-	// drop the size bits there we don't need to add another byte to the front
-	// of our encoded number
-	bitsAvailable := (bytes * 8) - bits
-	bitsNeeded := bytes
-	if bitsNeeded > bitsAvailable {
-		bytes++
+// Encodes the given integer into a VarInt which is exactly width bytes long,
+// e.g. to fill a field of a fixed size. IntegerTooBig is returned if the
+// integer doesn't fit, which includes it being the reserved value for the width
+// (see Unknown). InvalidVarInt is returned if the width isn't between 1 and 8.
+func EncodeWidth(i uint64, width int) (VarInt, error) {
+	if width < 1 || width > 8 {
+		return 0, InvalidVarInt
+	} else if i >= 1<<(7*width)-1 {
+		return 0, IntegerTooBig
 	}
+	marker := uint64(1) << (7 * width)
+	return VarInt(i | marker), nil
+}
 
-	one := 0x80 >> (bytes - 1)
-	shifted := uint64(one) << ((bytes - 1) * 8)
-	return VarInt(i | shifted), nil
+// Returns the reserved value for VarInts of the given width, which has all of
+// its data bits set (e.g. 0xff, 0x7fff, ..., 0x01ffffffffffffff). In ebml this
+// is used as the size of elements whose size wasn't known when they were
+// written. InvalidVarInt is returned if the width isn't between 1 and 8.
+func Unknown(width int) (VarInt, error) {
+	if width < 1 || width > 8 {
+		return 0, InvalidVarInt
+	}
+	return VarInt(1)<<(7*width+1) - 1, nil
 }
 
 // Returns the unencoded form of the VarInt
@@ -126,19 +176,33 @@ func (v VarInt) Uint64() (uint64, error) {
 	}
 }
 
+// Returns true if the VarInt is the reserved "unknown" value for its width, see
+// Unknown
+func (v VarInt) IsUnknown() bool {
+	for width := 1; width <= 8; width++ {
+		if u, _ := Unknown(width); v == u {
+			return true
+		}
+	}
+	return false
+}
+
 // Returns the number of bytes the encoded form of this varint would take up if
-// written out
+// written out. This is given by the marker bit in its first byte, so any 8 byte
+// value up to and including the reserved one is valid, but values whose first
+// byte doesn't have the right marker bit are not.
 func (v VarInt) Size() (int, error) {
 	if v > maxRaw || v < minRaw {
 		return 0, InvalidVarInt
 	}
-	mask := ^VarInt(0)
-	for i := byte(1); ; i++ {
-		mask <<= 8
-		if v & mask == 0 {
-			return int(i), nil
-		}
+	width := 1
+	for v>>(8*width) != 0 {
+		width++
+	}
+	if first := byte(v >> (8 * (width - 1))); int(numPrecedingZeros(first))+1 != width {
+		return 0, InvalidVarInt
 	}
+	return width, nil
 }
 
 // Returns a VarInt of equivalent value to this one but in its most compact
@@ -151,25 +215,36 @@ func (v VarInt) Normalize() (VarInt, error) {
 	return Encode(i)
 }
 
-// Writes the VarInt in its encoded form to the given io.Writer, implementing
-// the io.WriterTo interface
-func (v VarInt) WriteTo(w io.Writer) (int, error) {
-	if v > maxRaw || v < minRaw {
-		return 0, InvalidVarInt
+// Appends the VarInt in its encoded form to dst and returns the extended
+// slice. Nothing is allocated if dst has enough capacity
+func (v VarInt) Append(dst []byte) ([]byte, error) {
+	n, err := v.Size()
+	if err != nil {
+		return dst, err
+	}
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(v>>(8*i)))
 	}
+	return dst, nil
+}
 
-	out := make([]byte, 0, 8)
-	bytes := byte(1)
-	for thresh := VarInt(0xff); ; thresh = (thresh << 8) | thresh {
-		if v <= thresh {
-			break
-		}
-		bytes++
+// Encodes the given integer into the smallest possible VarInt, like Encode, and
+// appends it to dst. Nothing is allocated if dst has enough capacity
+func AppendEncode(dst []byte, i uint64) ([]byte, error) {
+	v, err := Encode(i)
+	if err != nil {
+		return dst, err
 	}
+	return v.Append(dst)
+}
 
-	for i := bytes - 1; i >= 0 && i < 255; i-- {
-		b := byte((v >> (i * 8)) & 0xff)
-		out = append(out, b)
+// Writes the VarInt in its encoded form to the given io.Writer, implementing
+// the io.WriterTo interface
+func (v VarInt) WriteTo(w io.Writer) (int, error) {
+	var b [8]byte
+	out, err := v.Append(b[:0])
+	if err != nil {
+		return 0, err
 	}
 	return w.Write(out)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	. "testing"
 )
 
@@ -60,3 +61,150 @@ func TestVarInt(t *T) {
 		assert.Equal(t, out, i, "input: 0x%x", in)
 	}
 }
+
+func TestIsUnknown(t *T) {
+	m := map[VarInt]bool{
+		VarInt(0xff):               true,
+		VarInt(0x7fff):             true,
+		VarInt(0x3fffff):           true,
+		VarInt(0x01ffffffffffffff): true,
+		VarInt(0xfe):               false,
+		VarInt(0x40ff):             false,
+		VarInt(0x81):               false,
+	}
+	for in, out := range m {
+		assert.Equal(t, out, in.IsUnknown(), "input: 0x%x", in)
+	}
+}
+
+func TestReadErrors(t *T) {
+	_, err := Read(bytes.NewBuffer([]byte{}))
+	assert.Equal(t, io.EOF, err)
+
+	_, err = Read(bytes.NewBuffer([]byte{0x40}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = Read(bytes.NewBuffer([]byte{0x00, 0x01}))
+	assert.Equal(t, InvalidVarInt, err)
+}
+
+func TestDecode(t *T) {
+	v, n, err := Decode([]byte{0x42, 0x86, 0xff})
+	require.Nil(t, err)
+	assert.Equal(t, VarInt(0x4286), v)
+	assert.Equal(t, 2, n)
+
+	_, _, err = Decode(nil)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = Decode([]byte{0x42})
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, _, err = Decode([]byte{0x00, 0x01})
+	assert.Equal(t, InvalidVarInt, err)
+
+	b := []byte{0x1a, 0x45, 0xdf, 0xa3}
+	allocs := AllocsPerRun(100, func() { Decode(b) })
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestAppendEncode(t *T) {
+	dst := make([]byte, 0, 16)
+	dst, err := AppendEncode(dst, 0x13ac)
+	require.Nil(t, err)
+	dst, err = VarInt(0x81).Append(dst)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x53, 0xac, 0x81}, dst)
+
+	_, err = AppendEncode(nil, MaxEncodable+1)
+	assert.Equal(t, IntegerTooBig, err)
+
+	allocs := AllocsPerRun(100, func() { AppendEncode(dst[:0], 0x13ac) })
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestReadByteReader(t *T) {
+	r := bytes.NewReader([]byte{0x42, 0x86, 0x81})
+	allocs := AllocsPerRun(100, func() {
+		r.Seek(0, io.SeekStart)
+		Read(r)
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	r.Seek(0, io.SeekStart)
+	v, err := Read(r)
+	require.Nil(t, err)
+	assert.Equal(t, VarInt(0x4286), v)
+	_, err = Read(bytes.NewReader([]byte{0x42}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestEncode(t *T) {
+	m := map[uint64]VarInt{
+		0:            VarInt(0x80),
+		126:          VarInt(0xfe),
+		127:          VarInt(0x407f),
+		0x3ffe:       VarInt(0x7ffe),
+		0x3fff:       VarInt(0x203fff),
+		MaxEncodable: VarInt(0x01fffffffffffffe),
+	}
+	for in, out := range m {
+		v, err := Encode(in)
+		require.Nil(t, err, "input: %d", in)
+		assert.Equal(t, out, v, "input: %d", in)
+		assert.False(t, v.IsUnknown(), "input: %d", in)
+	}
+}
+
+func TestEncodeWidth(t *T) {
+	v, err := EncodeWidth(5, 8)
+	require.Nil(t, err)
+	assert.Equal(t, VarInt(0x0100000000000005), v)
+	b, err := v.Append(nil)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x01, 0, 0, 0, 0, 0, 0, 0x05}, b)
+
+	v, err = EncodeWidth(0x13ac, 3)
+	require.Nil(t, err)
+	assert.Equal(t, VarInt(0x2013ac), v)
+	i, err := v.Uint64()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0x13ac), i)
+
+	_, err = EncodeWidth(0x7f, 1)
+	assert.Equal(t, IntegerTooBig, err)
+	_, err = EncodeWidth(0x4000, 2)
+	assert.Equal(t, IntegerTooBig, err)
+	_, err = EncodeWidth(1, 0)
+	assert.Equal(t, InvalidVarInt, err)
+	_, err = EncodeWidth(1, 9)
+	assert.Equal(t, InvalidVarInt, err)
+}
+
+func TestUnknown(t *T) {
+	for width := 1; width <= 8; width++ {
+		v, err := Unknown(width)
+		require.Nil(t, err)
+		assert.True(t, v.IsUnknown())
+		size, err := v.Size()
+		require.Nil(t, err)
+		assert.Equal(t, width, size)
+	}
+
+	v, err := Unknown(8)
+	require.Nil(t, err)
+	buf := new(bytes.Buffer)
+	_, err = v.WriteTo(buf)
+	require.Nil(t, err)
+	assert.Equal(t, []byte{0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, buf.Bytes())
+
+	_, err = Unknown(0)
+	assert.Equal(t, InvalidVarInt, err)
+}
+
+func TestSizeInvalid(t *T) {
+	for _, v := range []VarInt{0x7f, 0x0100, 0x8000, 0x02ffffffffffffff} {
+		_, err := v.Size()
+		assert.Equal(t, InvalidVarInt, err, "input: 0x%x", v)
+		_, err = v.WriteTo(new(bytes.Buffer))
+		assert.Equal(t, InvalidVarInt, err, "input: 0x%x", v)
+	}
+}